@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/archive"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"io"
+	"os"
+)
+
+func (a *application) export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "", "Write the archive to this file instead of stdout")
+	fs.Parse(args)
+
+	users, err := a.users.Export()
+	if err != nil {
+		return err
+	}
+
+	snippets, err := a.snippets.All()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = a.stdout
+	if *output != "" {
+		// the archive contains password hashes, keep it private
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err = archive.Write(w, users, snippets)
+	if err != nil {
+		return err
+	}
+
+	if *output != "" {
+		fmt.Fprintf(os.Stderr, "Exported %d users and %d snippets to %s.\n", len(users), len(snippets), *output)
+	}
+	return nil
+}
+
+// importArchive restores users first and then snippets, running it twice with the same archive changes nothing.
+func (a *application) importArchive(args []string) error {
+	var r io.Reader = a.stdin
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	arch, err := archive.Read(r)
+	if err != nil {
+		return err
+	}
+
+	var imported, skipped, conflicts int
+	report := func(kind string, id int, restored bool, err error) error {
+		switch {
+		case errors.Is(err, models.ErrConflict):
+			conflicts++
+			fmt.Fprintf(a.stdout, "conflict: %s #%d differs from the existing record, skipped\n", kind, id)
+		case err != nil:
+			return fmt.Errorf("%s #%d: %w", kind, id, err)
+		case restored:
+			imported++
+		default:
+			skipped++
+		}
+		return nil
+	}
+
+	// the ids of conflicting users can belong to someone else on this instance, their snippets
+	// would be attached to that user, so they are reported as conflicts as well
+	conflictingUsers := map[int]bool{}
+	for _, user := range arch.Users {
+		restored, err := a.users.Restore(user)
+		if errors.Is(err, models.ErrConflict) {
+			conflictingUsers[user.ID] = true
+		}
+		if err = report("user", user.ID, restored, err); err != nil {
+			return err
+		}
+	}
+
+	for _, snippet := range arch.Snippets {
+		if conflictingUsers[snippet.UserID] {
+			conflicts++
+			fmt.Fprintf(a.stdout, "conflict: snippet #%d belongs to the conflicting user #%d, skipped\n", snippet.ID, snippet.UserID)
+			continue
+		}
+		restored, err := a.snippets.Restore(snippet)
+		if err = report("snippet", snippet.ID, restored, err); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(a.stdout, "Imported %d records, skipped %d already present, %d conflicts.\n", imported, skipped, conflicts)
+	if conflicts > 0 {
+		return fmt.Errorf("%d records were not imported because of conflicts", conflicts)
+	}
+	return nil
+}
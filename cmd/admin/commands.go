@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/validator"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func (a *application) usersList() error {
+	users, err := a.users.All()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tEMAIL\tJOINED\tROLE\tSTATUS")
+	for _, user := range users {
+		status := "active"
+		if user.Disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", user.ID, user.Name, user.Email, user.CreatedAt.Format("2006-01-02"), user.Role, status)
+	}
+
+	return tw.Flush()
+}
+
+func (a *application) usersCreate(args []string) error {
+	fs := flag.NewFlagSet("users create", flag.ExitOnError)
+	name := fs.String("name", "", "User name")
+	email := fs.String("email", "", "User email address")
+	fs.Parse(args)
+
+	password, err := a.readPassword()
+	if err != nil {
+		return err
+	}
+
+	// apply the same rules as the signup form
+	var v validator.Validator
+	v.CheckField(validator.NotBlank(*name), "name", "cannot be blank")
+	v.CheckField(validator.NotBlank(*email), "email", "cannot be blank")
+	v.CheckField(validator.Matches(*email, validator.EmailRx), "email", "must be a valid email address")
+	v.CheckField(validator.MinChars(password, 8), "password", "must be at least 8 characters long")
+	if v.Invalid() {
+		return validationError(v)
+	}
+
+	id, err := a.users.Insert(*name, *email, password)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			return errors.New("email address is already in use")
+		}
+		return err
+	}
+
+	fmt.Fprintf(a.stdout, "Created user #%d.\n", id)
+	return nil
+}
+
+func (a *application) usersSetDisabled(args []string, disabled bool) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	err = a.users.SetDisabled(id, disabled)
+	if err != nil {
+		return notFound(err, "user", id)
+	}
+
+	if !disabled {
+		fmt.Fprintf(a.stdout, "Enabled user #%d.\n", id)
+		return nil
+	}
+
+	// log the user out everywhere right away instead of waiting for their next request
+	err = a.logOut(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.stdout, "Disabled user #%d.\n", id)
+	return nil
+}
+
+func (a *application) usersResetPassword(args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	password, err := a.readPassword()
+	if err != nil {
+		return err
+	}
+	if !validator.MinChars(password, 8) {
+		return errors.New("password must be at least 8 characters long")
+	}
+
+	err = a.users.UpdatePassword(id, password)
+	if err != nil {
+		return notFound(err, "user", id)
+	}
+
+	// whoever knew the old password may still be logged in or hold a reset link
+	err = a.logOut(id)
+	if err != nil {
+		return err
+	}
+	err = a.tokens.DeleteAllForUser(models.ScopePasswordReset, id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.stdout, "Password of user #%d has been reset.\n", id)
+	return nil
+}
+
+func (a *application) usersSetRole(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected an ID and a role")
+	}
+
+	id, err := parseID(args[:1])
+	if err != nil {
+		return err
+	}
+
+	role := args[1]
+	if !models.ValidRole(role) {
+		return fmt.Errorf("invalid role %q, must be one of %s", role, strings.Join(models.Roles, ", "))
+	}
+
+	err = a.users.SetRole(id, role)
+	if err != nil {
+		return notFound(err, "user", id)
+	}
+
+	fmt.Fprintf(a.stdout, "User #%d is now a %s.\n", id, role)
+	return nil
+}
+
+func (a *application) snippetsDelete(args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	err = a.snippets.DeleteByID(id)
+	if err != nil {
+		return notFound(err, "snippet", id)
+	}
+
+	fmt.Fprintf(a.stdout, "Deleted snippet #%d.\n", id)
+	return nil
+}
+
+func (a *application) purge(args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	// the same flag as the web server, a remembered session that was not used for this long has expired
+	rememberLifetime := fs.Duration("session-remember-lifetime", 30*24*time.Hour, "Maximum age of a remembered session, as configured on the web server")
+	fs.Parse(args)
+
+	snippets, err := a.snippets.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	tokens, err := a.tokens.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	attempts, err := a.attempts.DeleteStale(24 * time.Hour)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := a.sessions.DeleteStale(*rememberLifetime)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.stdout, "Purged %d expired snippets, %d expired tokens, %d old login attempts and %d stale sessions.\n",
+		snippets, tokens, attempts, sessions)
+	return nil
+}
+
+func (a *application) stats() error {
+	users, disabled, err := a.users.Counts()
+	if err != nil {
+		return err
+	}
+
+	active, expired, err := a.snippets.Counts()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Users:\t%d\n", users)
+	fmt.Fprintf(tw, "Disabled users:\t%d\n", disabled)
+	fmt.Fprintf(tw, "Active snippets:\t%d\n", active)
+	fmt.Fprintf(tw, "Expired snippets:\t%d\n", expired)
+
+	return tw.Flush()
+}
+
+// logOut ends all sessions of a user and revokes their API tokens.
+func (a *application) logOut(id int) error {
+	err := a.sessions.DeleteAllForUser(id, "")
+	if err != nil {
+		return err
+	}
+
+	return a.tokens.DeleteAllForUser(models.ScopeAuthentication, id)
+}
+
+// readPassword reads a single line from stdin so passwords don't end up in the shell history.
+func (a *application) readPassword() (string, error) {
+	password, err := bufio.NewReader(a.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	return strings.TrimRight(password, "\r\n"), nil
+}
+
+func parseID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected exactly one ID")
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid ID %q", args[0])
+	}
+
+	return id, nil
+}
+
+func notFound(err error, kind string, id int) error {
+	if errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("%s #%d does not exist", kind, id)
+	}
+	return err
+}
+
+func validationError(v validator.Validator) error {
+	var messages []string
+	for field, message := range v.FieldErrors {
+		messages = append(messages, fmt.Sprintf("%s %s", field, message))
+	}
+	sort.Strings(messages)
+	return errors.New(strings.Join(messages, "; "))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsersCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		password   string
+		wantErr    string
+		wantOutput string
+	}{
+		{
+			name:       "valid",
+			args:       []string{"-name", "Bob", "-email", "bob@example.com"},
+			password:   "pa$$word123\n",
+			wantOutput: "Created user #2.\n",
+		},
+		{
+			name:     "invalid",
+			args:     []string{"-email", "bob"},
+			password: "short\n",
+			wantErr:  "email must be a valid email address; name cannot be blank; password must be at least 8 characters long",
+		},
+		{
+			name:     "duplicate email",
+			args:     []string{"-name", "Alice", "-email", "alice@example.com"},
+			password: "pa$$word123",
+			wantErr:  "email address is already in use",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, stdout := newTestApplication(tt.password)
+
+			err := a.run(append([]string{"users", "create"}, tt.args...))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got error %v; want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stdout.String() != tt.wantOutput {
+				t.Errorf("got output %q; want %q", stdout.String(), tt.wantOutput)
+			}
+			if got := a.users.(*fakeUsers).passwords[2]; got != "pa$$word123" {
+				t.Errorf("got password %q; want %q", got, "pa$$word123")
+			}
+		})
+	}
+}
+
+func TestUsersSetDisabled(t *testing.T) {
+	a, stdout := newTestApplication("")
+
+	err := a.run([]string{"users", "disable", "#1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.users.(*fakeUsers).users[1].Disabled {
+		t.Error("got an active user; want disabled")
+	}
+	if got := a.sessions.(*fakeSessions).loggedOut; !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("got sessions of users %v deleted; want [1]", got)
+	}
+	if got, want := a.tokens.(*fakeTokens).revoked, []string{models.ScopeAuthentication + " #1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got revoked tokens %v; want %v", got, want)
+	}
+
+	err = a.run([]string{"users", "enable", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.users.(*fakeUsers).users[1].Disabled {
+		t.Error("got a disabled user; want active")
+	}
+	if want := "Disabled user #1.\nEnabled user #1.\n"; stdout.String() != want {
+		t.Errorf("got output %q; want %q", stdout.String(), want)
+	}
+
+	err = a.run([]string{"users", "disable", "2"})
+	if err == nil || err.Error() != "user #2 does not exist" {
+		t.Errorf("got error %v; want user #2 does not exist", err)
+	}
+}
+
+func TestUsersResetPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		id       string
+		password string
+		wantErr  string
+	}{
+		{name: "valid", id: "1", password: "new pa$$word\r\n"},
+		{name: "short password", id: "1", password: "short\n", wantErr: "password must be at least 8 characters long"},
+		{name: "unknown user", id: "2", password: "new pa$$word\n", wantErr: "user #2 does not exist"},
+		{name: "invalid ID", id: "one", password: "new pa$$word\n", wantErr: `invalid ID "one"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, stdout := newTestApplication(tt.password)
+
+			err := a.run([]string{"users", "reset-password", tt.id})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got error %v; want %q", err, tt.wantErr)
+				}
+				if a.users.(*fakeUsers).passwords[1] != "pa$$word123" || len(a.tokens.(*fakeTokens).revoked) != 0 {
+					t.Error("got the password changed or tokens revoked after an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := a.users.(*fakeUsers).passwords[1]; got != "new pa$$word" {
+				t.Errorf("got password %q; want %q", got, "new pa$$word")
+			}
+			if got := a.sessions.(*fakeSessions).loggedOut; !reflect.DeepEqual(got, []int{1}) {
+				t.Errorf("got sessions of users %v deleted; want [1]", got)
+			}
+			want := []string{models.ScopeAuthentication + " #1", models.ScopePasswordReset + " #1"}
+			if got := a.tokens.(*fakeTokens).revoked; !reflect.DeepEqual(got, want) {
+				t.Errorf("got revoked tokens %v; want %v", got, want)
+			}
+			if want := "Password of user #1 has been reset.\n"; stdout.String() != want {
+				t.Errorf("got output %q; want %q", stdout.String(), want)
+			}
+		})
+	}
+}
+
+func TestUsersSetRole(t *testing.T) {
+	a, stdout := newTestApplication("")
+
+	err := a.run([]string{"users", "role", "1", models.RoleModerator})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.users.(*fakeUsers).users[1].Role; got != models.RoleModerator {
+		t.Errorf("got role %q; want %q", got, models.RoleModerator)
+	}
+	if want := "User #1 is now a moderator.\n"; stdout.String() != want {
+		t.Errorf("got output %q; want %q", stdout.String(), want)
+	}
+
+	err = a.run([]string{"users", "role", "1", "root"})
+	if err == nil || !strings.HasPrefix(err.Error(), `invalid role "root"`) {
+		t.Errorf("got error %v; want an invalid role", err)
+	}
+}
+
+func TestUsersList(t *testing.T) {
+	a, stdout := newTestApplication("")
+	a.users.(*fakeUsers).users[1].Disabled = true
+
+	err := a.run([]string{"users", "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("got output %q; want a header and one user", stdout.String())
+	}
+	if fields := strings.Fields(lines[1]); fields[1] != "Alice" || fields[len(fields)-1] != "disabled" {
+		t.Errorf("got user %q; want Alice, disabled", lines[1])
+	}
+}
+
+func TestSnippetsDelete(t *testing.T) {
+	a, stdout := newTestApplication("")
+
+	err := a.run([]string{"snippets", "delete", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.snippets.(*fakeSnippets).snippets) != 0 {
+		t.Error("got the snippet kept; want deleted")
+	}
+	if want := "Deleted snippet #1.\n"; stdout.String() != want {
+		t.Errorf("got output %q; want %q", stdout.String(), want)
+	}
+
+	err = a.run([]string{"snippets", "delete", "1"})
+	if err == nil || err.Error() != "snippet #1 does not exist" {
+		t.Errorf("got error %v; want snippet #1 does not exist", err)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		wantOlderThan time.Duration
+	}{
+		{name: "default lifetime", wantOlderThan: 30 * 24 * time.Hour},
+		{name: "configured lifetime", args: []string{"-session-remember-lifetime", "48h"}, wantOlderThan: 48 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, stdout := newTestApplication("")
+
+			err := a.run(append([]string{"purge"}, tt.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := a.sessions.(*fakeSessions).olderThan; got != tt.wantOlderThan {
+				t.Errorf("got sessions unused for %v deleted; want %v", got, tt.wantOlderThan)
+			}
+			if got := a.attempts.(*fakeAttempts).olderThan; got != 24*time.Hour {
+				t.Errorf("got login attempts older than %v deleted; want %v", got, 24*time.Hour)
+			}
+			want := "Purged 2 expired snippets, 1 expired tokens, 3 old login attempts and 4 stale sessions.\n"
+			if stdout.String() != want {
+				t.Errorf("got output %q; want %q", stdout.String(), want)
+			}
+		})
+	}
+}
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{args: []string{"12"}, want: 12},
+		{args: []string{"#12"}, want: 12},
+		{args: []string{"0"}, wantErr: true},
+		{args: []string{"-1"}, wantErr: true},
+		{args: []string{"abc"}, wantErr: true},
+		{args: nil, wantErr: true},
+		{args: []string{"1", "2"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseID(tt.args)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseID(%q): got %d, %v; want %d, error %v", tt.args, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	_ "github.com/go-sql-driver/mysql"
+	"io"
+	"os"
+	"time"
+)
+
+const usage = `Usage: admin [-dsn DSN] <command> [arguments]
+
+Commands:
+  users list                        list all users
+  users create -name NAME -email E  create a user, the password is read from stdin
+  users disable ID                  prevent a user from logging in
+  users enable ID                   allow a disabled user to log in again
+  users reset-password ID           set a new password read from stdin
+  users role ID ROLE                set the role of a user (user, moderator or admin)
+  snippets delete ID                delete any snippet
+  purge [-session-remember-lifetime D]
+                                    delete expired snippets, tokens, old login attempts and sessions
+                                    unused for D (default 720h, match the web server's flag)
+  export [-o FILE]                  write all users and snippets as JSON Lines (default stdout)
+  import [FILE]                     import an export, existing identical records are skipped (default stdin)
+  stats                             print instance statistics
+`
+
+// the commands use the models through these interfaces so they can be tested without a database
+
+type userStore interface {
+	All() ([]*models.User, error)
+	Insert(name, email, password string) (int, error)
+	SetDisabled(id int, disabled bool) error
+	UpdatePassword(id int, password string) error
+	SetRole(id int, role string) error
+	Counts() (total, disabled int, err error)
+	Export() ([]*models.User, error)
+	Restore(user *models.User) (bool, error)
+}
+
+type snippetStore interface {
+	DeleteByID(id int) error
+	DeleteExpired() (int, error)
+	Counts() (active, expired int, err error)
+	All() ([]*models.Snippet, error)
+	Restore(snippet *models.Snippet) (bool, error)
+}
+
+type tokenStore interface {
+	DeleteAllForUser(scope string, userID int) error
+	DeleteExpired() (int, error)
+}
+
+type attemptStore interface {
+	DeleteStale(olderThan time.Duration) (int, error)
+}
+
+type sessionStore interface {
+	DeleteAllForUser(userID int, exceptID string) error
+	DeleteStale(olderThan time.Duration) (int, error)
+}
+
+type application struct {
+	snippets snippetStore
+	users    userStore
+	tokens   tokenStore
+	attempts attemptStore
+	sessions sessionStore
+	stdin    io.Reader
+	stdout   io.Writer
+}
+
+func main() {
+	dsn := flag.String("dsn", "root:@/snippetbox?parseTime=true", "MySQL data source name")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	app := &application{
+		snippets: &models.SnippetModel{DB: db},
+		users:    &models.UserModel{DB: db},
+		tokens:   &models.TokenModel{DB: db},
+		attempts: &models.LoginAttemptModel{DB: db},
+		sessions: &models.SessionModel{DB: db},
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+	}
+
+	err = app.run(flag.Args())
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func (a *application) run(args []string) error {
+	command, args := args[0], args[1:]
+	if command == "users" || command == "snippets" {
+		if len(args) == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		command, args = command+" "+args[0], args[1:]
+	}
+
+	switch command {
+	case "users list":
+		return a.usersList()
+	case "users create":
+		return a.usersCreate(args)
+	case "users disable":
+		return a.usersSetDisabled(args, true)
+	case "users enable":
+		return a.usersSetDisabled(args, false)
+	case "users reset-password":
+		return a.usersResetPassword(args)
+	case "users role":
+		return a.usersSetRole(args)
+	case "snippets delete":
+		return a.snippetsDelete(args)
+	case "purge":
+		return a.purge(args)
+	case "stats":
+		return a.stats()
+	case "export":
+		return a.export(args)
+	case "import":
+		return a.importArchive(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	return nil
+}
+
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "admin: %s\n", err)
+	os.Exit(1)
+}
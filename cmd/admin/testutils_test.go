@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"strings"
+	"time"
+)
+
+type fakeUsers struct {
+	users     map[int]*models.User
+	passwords map[int]string
+}
+
+func (f *fakeUsers) All() ([]*models.User, error) {
+	var users []*models.User
+	for id := 1; id <= len(f.users); id++ {
+		users = append(users, f.users[id])
+	}
+	return users, nil
+}
+
+func (f *fakeUsers) Insert(name, email, password string) (int, error) {
+	for _, user := range f.users {
+		if user.Email == email {
+			return 0, models.ErrDuplicateEmail
+		}
+	}
+	id := len(f.users) + 1
+	f.users[id] = &models.User{ID: id, Name: name, Email: email, Role: models.RoleUser}
+	f.passwords[id] = password
+	return id, nil
+}
+
+func (f *fakeUsers) SetDisabled(id int, disabled bool) error {
+	user, ok := f.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	user.Disabled = disabled
+	return nil
+}
+
+func (f *fakeUsers) UpdatePassword(id int, password string) error {
+	if _, ok := f.users[id]; !ok {
+		return models.ErrNoRecord
+	}
+	f.passwords[id] = password
+	return nil
+}
+
+func (f *fakeUsers) SetRole(id int, role string) error {
+	user, ok := f.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	user.Role = role
+	return nil
+}
+
+func (f *fakeUsers) Counts() (total, disabled int, err error) {
+	for _, user := range f.users {
+		total++
+		if user.Disabled {
+			disabled++
+		}
+	}
+	return total, disabled, nil
+}
+
+func (f *fakeUsers) Export() ([]*models.User, error) {
+	return f.All()
+}
+
+func (f *fakeUsers) Restore(user *models.User) (bool, error) {
+	return false, nil
+}
+
+type fakeSnippets struct {
+	snippets map[int]*models.Snippet
+	expired  int
+}
+
+func (f *fakeSnippets) DeleteByID(id int) error {
+	if _, ok := f.snippets[id]; !ok {
+		return models.ErrNoRecord
+	}
+	delete(f.snippets, id)
+	return nil
+}
+
+func (f *fakeSnippets) DeleteExpired() (int, error) {
+	n := f.expired
+	f.expired = 0
+	return n, nil
+}
+
+func (f *fakeSnippets) Counts() (active, expired int, err error) {
+	return len(f.snippets), f.expired, nil
+}
+
+func (f *fakeSnippets) All() ([]*models.Snippet, error) {
+	return nil, nil
+}
+
+func (f *fakeSnippets) Restore(snippet *models.Snippet) (bool, error) {
+	return false, nil
+}
+
+type fakeTokens struct {
+	// revoked holds a "scope #id" entry for every DeleteAllForUser call
+	revoked []string
+	expired int
+}
+
+func (f *fakeTokens) DeleteAllForUser(scope string, userID int) error {
+	f.revoked = append(f.revoked, fmt.Sprintf("%s #%d", scope, userID))
+	return nil
+}
+
+func (f *fakeTokens) DeleteExpired() (int, error) {
+	return f.expired, nil
+}
+
+type fakeAttempts struct {
+	olderThan time.Duration
+}
+
+func (f *fakeAttempts) DeleteStale(olderThan time.Duration) (int, error) {
+	f.olderThan = olderThan
+	return 3, nil
+}
+
+type fakeSessions struct {
+	loggedOut []int
+	olderThan time.Duration
+}
+
+func (f *fakeSessions) DeleteAllForUser(userID int, exceptID string) error {
+	if exceptID == "" {
+		f.loggedOut = append(f.loggedOut, userID)
+	}
+	return nil
+}
+
+func (f *fakeSessions) DeleteStale(olderThan time.Duration) (int, error) {
+	f.olderThan = olderThan
+	return 4, nil
+}
+
+// newTestApplication returns an application with user #1 Alice and snippet #1, and a buffer collecting its output.
+// The password for the commands that read one is taken from stdin.
+func newTestApplication(stdin string) (*application, *bytes.Buffer) {
+	var stdout bytes.Buffer
+	a := &application{
+		snippets: &fakeSnippets{snippets: map[int]*models.Snippet{1: {ID: 1, UserID: 1, Title: "Title"}}, expired: 2},
+		users: &fakeUsers{
+			users:     map[int]*models.User{1: {ID: 1, Name: "Alice", Email: "alice@example.com", Role: models.RoleUser}},
+			passwords: map[int]string{1: "pa$$word123"},
+		},
+		tokens:   &fakeTokens{expired: 1},
+		attempts: &fakeAttempts{},
+		sessions: &fakeSessions{},
+		stdin:    strings.NewReader(stdin),
+		stdout:   &stdout,
+	}
+	return a, &stdout
+}
@@ -0,0 +1,34 @@
+// Command fakeidp runs a fake OpenID Connect provider for trying out SSO logins locally.
+// It logs in everyone as the user given by the flags without asking for a password.
+//
+// Start it next to the web server and point the web server at it with -oidc-providers:
+//
+//	go run ./cmd/fakeidp -addr :4001 -email alice@example.com
+//	echo '[{"name": "fake", "display_name": "Fake SSO", "issuer": "http://localhost:4001",
+//	  "client_id": "snippetbox", "client_secret": "secret", "auto_provision": true}]' > oidc.json
+//	go run ./cmd/web -oidc-providers oidc.json
+package main
+
+import (
+	"flag"
+	"github.com/danyelkeddah/snippetbox/internal/oidc/oidctest"
+	"log"
+	"net/http"
+)
+
+func main() {
+	addr := flag.String("addr", ":4001", "HTTP network address")
+	issuer := flag.String("issuer", "http://localhost:4001", "Issuer URL, must be the URL the provider is reachable at")
+	subject := flag.String("subject", "fake-user", "Subject of the logged in user")
+	email := flag.String("email", "fake@example.com", "Email address of the logged in user")
+	name := flag.String("name", "Fake User", "Name of the logged in user")
+	unverified := flag.Bool("unverified", false, "Report the email address as not verified")
+	flag.Parse()
+
+	provider := oidctest.NewProvider(*issuer)
+	provider.SetUser(oidctest.User{Subject: *subject, Email: *email, EmailVerified: !*unverified, Name: *name})
+
+	log.Printf("fake OpenID Connect provider for %s (client id %q, secret %q) on %s",
+		*issuer, oidctest.ClientID, oidctest.ClientSecret, *addr)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errNotFound     = errors.New("snippet not found")
+	errUnauthorized = errors.New("not logged in or the token has expired, run snippet login")
+)
+
+type snippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	URL     string    `json:"url"`
+}
+
+// client talks to the snippetbox JSON API
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func newClient(server, token string, insecure bool) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		// the development server uses a self-signed certificate
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &client{
+		server: strings.TrimRight(server, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+func (c *client) login(email, password, code string) (string, error) {
+	input := map[string]string{"email": email, "password": password, "code": code}
+	var output struct {
+		Token string `json:"token"`
+	}
+
+	err := c.do(http.MethodPost, "/api/tokens", input, &output)
+	if err != nil {
+		return "", err
+	}
+
+	return output.Token, nil
+}
+
+// logout revokes the token on the server
+func (c *client) logout() error {
+	return c.do(http.MethodDelete, "/api/tokens", nil, nil)
+}
+
+func (c *client) create(title, content string, expires int) (*snippet, error) {
+	input := map[string]any{"title": title, "content": content, "expires": expires}
+	var output struct {
+		Snippet *snippet `json:"snippet"`
+	}
+
+	err := c.do(http.MethodPost, "/api/snippets", input, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Snippet, nil
+}
+
+func (c *client) get(id int) (*snippet, error) {
+	var output struct {
+		Snippet *snippet `json:"snippet"`
+	}
+
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/snippets/%d", id), nil, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Snippet, nil
+}
+
+func (c *client) list() ([]*snippet, error) {
+	var output struct {
+		Snippets []*snippet `json:"snippets"`
+	}
+
+	err := c.do(http.MethodGet, "/api/snippets", nil, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Snippets, nil
+}
+
+func (c *client) delete(id int) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/snippets/%d", id), nil, nil)
+}
+
+func (c *client) do(method, path string, input, output any) error {
+	var body io.Reader
+	if input != nil {
+		js, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return responseError(res)
+	}
+
+	if output == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(output)
+}
+
+// responseError turns the {"error": ...} body returned by the API into a Go error.
+func responseError(res *http.Response) error {
+	if res.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	// a rejected token, as opposed to a failed login, comes with a WWW-Authenticate challenge
+	if res.StatusCode == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") != "" {
+		return errUnauthorized
+	}
+
+	var output struct {
+		Error any `json:"error"`
+	}
+	err := json.NewDecoder(res.Body).Decode(&output)
+	if err != nil || output.Error == nil {
+		return fmt.Errorf("server responded with %s", res.Status)
+	}
+
+	switch e := output.Error.(type) {
+	case string:
+		return errors.New(e)
+	case map[string]any:
+		var messages []string
+		for field, message := range e {
+			messages = append(messages, fmt.Sprintf("%s: %v", field, message))
+		}
+		return errors.New(strings.Join(messages, "; "))
+	default:
+		return fmt.Errorf("server responded with %s", res.Status)
+	}
+}
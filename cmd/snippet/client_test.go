@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		header  http.Header
+		body    string
+		want    string
+		wantErr error
+	}{
+		{name: "not found", status: http.StatusNotFound, body: `{"error":"the requested resource could not be found"}`, wantErr: errNotFound},
+		{name: "rejected token", status: http.StatusUnauthorized, header: http.Header{"Www-Authenticate": {"Bearer"}}, wantErr: errUnauthorized},
+		{name: "failed login", status: http.StatusUnauthorized, body: `{"error":"Email or password is incorrect"}`, want: "Email or password is incorrect"},
+		{name: "validation", status: http.StatusUnprocessableEntity, body: `{"error":{"title":"This field cannot be blank"}}`, want: "title: This field cannot be blank"},
+		{name: "no JSON", status: http.StatusBadGateway, body: "<html>", want: "server responded with 502 Bad Gateway"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &http.Response{
+				StatusCode: tt.status,
+				Status:     fmt.Sprintf("%d %s", tt.status, http.StatusText(tt.status)),
+				Header:     tt.header,
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+
+			err := responseError(res)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("got error %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v; want %q", err, tt.want)
+			}
+		})
+	}
+}
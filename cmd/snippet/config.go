@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tokenPath returns where the authentication token is stored,
+// e.g. ~/.config/snippet/token on Linux.
+func tokenPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "snippet", "token"), nil
+}
+
+// loadToken returns the stored token, SNIPPET_TOKEN takes precedence so scripts don't need to log in.
+func loadToken() (string, error) {
+	if token := os.Getenv("SNIPPET_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	path, err := tokenPath()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func saveToken(token string) error {
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return err
+	}
+
+	// the token grants full access to the account, keep it private
+	return os.WriteFile(path, []byte(token+"\n"), 0o600)
+}
+
+func removeToken() error {
+	path, err := tokenPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
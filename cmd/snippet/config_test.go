@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// useTempConfigDir points the user config directory at a temporary directory for the test.
+func useTempConfigDir(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("SNIPPET_TOKEN", "")
+}
+
+func TestTokenStorage(t *testing.T) {
+	useTempConfigDir(t)
+
+	token, err := loadToken()
+	if err != nil || token != "" {
+		t.Fatalf("got %q, %v before logging in; want no token", token, err)
+	}
+
+	err = saveToken("ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := tokenPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("got file mode %v; want %v", perm, os.FileMode(0o600))
+	}
+
+	token, err = loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "ABCDEFGHIJKLMNOPQRSTUVWXYZ" {
+		t.Errorf("got token %q; want %q", token, "ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	}
+
+	// the environment wins over the stored token
+	t.Setenv("SNIPPET_TOKEN", "FROMTHEENVIRONMENT")
+	token, err = loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "FROMTHEENVIRONMENT" {
+		t.Errorf("got token %q; want %q", token, "FROMTHEENVIRONMENT")
+	}
+	t.Setenv("SNIPPET_TOKEN", "")
+
+	// removing it twice is fine
+	for i := 0; i < 2; i++ {
+		err = removeToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err = loadToken()
+	if err != nil || token != "" {
+		t.Errorf("got %q, %v after removing it; want no token", token, err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const usage = `Usage: snippet [-server URL] [-insecure] <command> [arguments]
+
+Commands:
+  login -email EMAIL [-code CODE]
+                          exchange email and password (read from stdin) for a token,
+                          -code is the two-factor code if it is enabled for the account
+  logout                  revoke the stored token and forget it
+  create -t TITLE [-e 7d] create a snippet from stdin and print its URL
+  get ID                  print the content of a snippet
+  list                    list the latest snippets
+  delete ID               delete one of your snippets
+
+Expiry accepts 1d, 7d, 365d, 1w or 1y.
+`
+
+type cli struct {
+	client *client
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func main() {
+	server := flag.String("server", envOr("SNIPPET_SERVER", "https://localhost:4000"), "Snippetbox server URL")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	token, err := loadToken()
+	if err != nil {
+		fatal(err)
+	}
+
+	c := &cli{
+		client: newClient(*server, token, *insecure),
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "login":
+		err = c.login(args)
+	case "logout":
+		err = c.logout()
+	case "create":
+		err = c.create(args)
+	case "get":
+		err = c.get(args)
+	case "list":
+		err = c.list(args)
+	case "delete":
+		err = c.delete(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func (c *cli) login(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	email := fs.String("email", "", "Account email address")
+	code := fs.String("code", "", "Two-factor authentication or recovery code")
+	fs.Parse(args)
+
+	if *email == "" {
+		return errors.New("login: -email is required")
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(c.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	token, err := c.client.login(*email, strings.TrimRight(password, "\r\n"), *code)
+	if err != nil {
+		return err
+	}
+
+	err = saveToken(token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, "Logged in.")
+	return nil
+}
+
+func (c *cli) logout() error {
+	if c.client.token != "" {
+		err := c.client.logout()
+		// an expired or already revoked token is as good as a revoked one
+		if err != nil && !errors.Is(err, errUnauthorized) {
+			return fmt.Errorf("logout: revoking the token failed, it is still stored: %w", err)
+		}
+	}
+
+	err := removeToken()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, "Logged out.")
+	return nil
+}
+
+func (c *cli) create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	title := fs.String("t", "", "Snippet title")
+	expires := fs.String("e", "365d", "Delete the snippet after 1d, 7d or 365d")
+	fs.Parse(args)
+
+	if *title == "" {
+		return errors.New("create: -t is required")
+	}
+
+	days, err := parseExpires(*expires)
+	if err != nil {
+		return err
+	}
+
+	content, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return err
+	}
+
+	s, err := c.client.create(*title, string(content), days)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(c.stdout, s.URL)
+	return nil
+}
+
+func (c *cli) get(args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	s, err := c.client.get(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(c.stdout, s.Content)
+	if !strings.HasSuffix(s.Content, "\n") {
+		fmt.Fprintln(c.stdout)
+	}
+	return nil
+}
+
+func (c *cli) list(args []string) error {
+	snippets, err := c.client.list()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tEXPIRES\tURL")
+	for _, s := range snippets {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.ID, s.Title, s.Expires.Format("2006-01-02"), s.URL)
+	}
+
+	return tw.Flush()
+}
+
+func (c *cli) delete(args []string) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	err = c.client.delete(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stdout, "Deleted snippet #%d.\n", id)
+	return nil
+}
+
+func parseID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected exactly one snippet ID")
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid snippet ID %q", args[0])
+	}
+
+	return id, nil
+}
+
+// parseExpires converts values like 7d, 1w or 1y into days, the server only accepts 1, 7 or 365.
+func parseExpires(raw string) (int, error) {
+	value := strings.TrimSpace(strings.ToLower(raw))
+	if value == "" {
+		return 0, errors.New("expiry cannot be blank")
+	}
+
+	multiplier := 1
+	switch value[len(value)-1] {
+	case 'd':
+		value = value[:len(value)-1]
+	case 'w':
+		multiplier = 7
+		value = value[:len(value)-1]
+	case 'y':
+		multiplier = 365
+		value = value[:len(value)-1]
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid expiry %q", raw)
+	}
+
+	return n * multiplier, nil
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "snippet: %s\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestCLI returns a cli talking to a fake server that handles the token endpoints.
+// The server issues the token "NEWTOKEN", revoking answers with revokeStatus and records the token it was sent.
+func newTestCLI(t *testing.T, token string, revokeStatus int) (*cli, *bytes.Buffer, *string) {
+	t.Helper()
+
+	var revoked string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/tokens":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token":"NEWTOKEN"}`))
+		case "DELETE /api/tokens":
+			revoked = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if revokeStatus == http.StatusUnauthorized {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			w.WriteHeader(revokeStatus)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	var stdout bytes.Buffer
+	c := &cli{
+		client: newClient(srv.URL, token, false),
+		stdin:  strings.NewReader("pa$$word123\n"),
+		stdout: &stdout,
+	}
+	return c, &stdout, &revoked
+}
+
+func TestLogin(t *testing.T) {
+	useTempConfigDir(t)
+	c, stdout, _ := newTestCLI(t, "", http.StatusNoContent)
+
+	err := c.login([]string{"-email", "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := loadToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "NEWTOKEN" {
+		t.Errorf("got stored token %q; want %q", token, "NEWTOKEN")
+	}
+	if stdout.String() != "Logged in.\n" {
+		t.Errorf("got output %q; want %q", stdout.String(), "Logged in.\n")
+	}
+}
+
+func TestLogout(t *testing.T) {
+	tests := []struct {
+		name         string
+		revokeStatus int
+		wantErr      bool
+	}{
+		{name: "revoked", revokeStatus: http.StatusNoContent},
+		{name: "already expired", revokeStatus: http.StatusUnauthorized},
+		{name: "server error", revokeStatus: http.StatusInternalServerError, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempConfigDir(t)
+			err := saveToken("OLDTOKEN")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, _, revoked := newTestCLI(t, "OLDTOKEN", tt.revokeStatus)
+
+			err = c.logout()
+			if *revoked != "OLDTOKEN" {
+				t.Errorf("got %q revoked; want %q", *revoked, "OLDTOKEN")
+			}
+
+			token, loadErr := loadToken()
+			if loadErr != nil {
+				t.Fatal(loadErr)
+			}
+			if tt.wantErr {
+				// the token still works, so it is kept for another try
+				if err == nil || token != "OLDTOKEN" {
+					t.Errorf("got error %v and stored token %q; want an error and the token kept", err, token)
+				}
+				return
+			}
+			if err != nil || token != "" {
+				t.Errorf("got error %v and stored token %q; want the token removed", err, token)
+			}
+		})
+	}
+}
+
+func TestParseExpires(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		{raw: "7d", want: 7},
+		{raw: "7", want: 7},
+		{raw: "1w", want: 7},
+		{raw: " 1Y ", want: 365},
+		{raw: "", wantErr: true},
+		{raw: "0d", wantErr: true},
+		{raw: "week", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseExpires(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseExpires(%q): got %d, %v; want %d, error %v", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// responseRecorder - wraps a ResponseWriter to record the status code and the number of bytes written
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if !rr.wroteHeader {
+		rr.WriteHeader(http.StatusOK)
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.size += n
+	return n, err
+}
+
+// Unwrap - lets http.ResponseController reach the underlying ResponseWriter, e.g. to flush
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// accessLogger - writes one line per request in the configured format:
+// "log" adds a record to the application log, "common" and "combined" are the Apache formats and "json" writes JSON Lines
+type accessLogger struct {
+	format string
+	// records receives the entries in the "log" format, out the lines of every other format
+	records *slog.Logger
+	out     io.Writer
+	// logger reports failures to write the access log
+	logger *slog.Logger
+	mu     sync.Mutex
+}
+
+var accessLogFormats = []string{"log", "common", "combined", "json"}
+
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Size       int       `json:"size"`
+	DurationMS float64   `json:"duration_ms"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+func (l *accessLogger) log(r *http.Request, rr *responseRecorder, start time.Time) {
+	status := rr.status
+	if status == 0 {
+		// the handler did not write anything, net/http sends an empty 200 response
+		status = http.StatusOK
+	}
+
+	// the Referer is redacted as well, pages behind a token link send it along with their next request
+	e := accessLogEntry{
+		Time:       start,
+		RequestID:  getRequestID(r),
+		RemoteAddr: clientIP(r),
+		Method:     r.Method,
+		URI:        redactURL(r.URL.RequestURI()),
+		Proto:      r.Proto,
+		Status:     status,
+		Size:       rr.size,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		Referer:    redactURL(r.Referer()),
+		UserAgent:  r.UserAgent(),
+	}
+
+	if l.format == "log" {
+		l.records.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("ip", e.RemoteAddr),
+			slog.String("proto", e.Proto),
+			slog.String("method", e.Method),
+			slog.String("uri", e.URI),
+			slog.Int("status", e.Status),
+			slog.Int("size", e.Size),
+			slog.Float64("duration_ms", e.DurationMS),
+		)
+		return
+	}
+
+	var line []byte
+	switch l.format {
+	case "json":
+		js, err := json.Marshal(e)
+		if err != nil {
+			l.logger.Error("encoding access log entry failed", "error", err)
+			return
+		}
+		line = append(js, '\n')
+	default:
+		size := "-"
+		if e.Size > 0 {
+			size = strconv.Itoa(e.Size)
+		}
+		line = fmt.Appendf(nil, "%s - - [%s] %q %d %s", e.RemoteAddr, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method+" "+e.URI+" "+e.Proto, e.Status, size)
+		if l.format == "combined" {
+			line = fmt.Appendf(line, " %q %q", orDash(e.Referer), orDash(e.UserAgent))
+		}
+		line = append(line, '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.out.Write(line)
+	if err != nil {
+		l.logger.Error("writing access log failed", "error", err)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
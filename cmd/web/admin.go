@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// adminListLimit is the maximum number of rows shown on the admin lists, search to narrow them down.
+	adminListLimit = 100
+	// growthDays is the number of days shown in the growth table of the dashboard.
+	growthDays = 30
+)
+
+type adminStats struct {
+	Users           int
+	DisabledUsers   int
+	ActiveSnippets  int
+	ExpiredSnippets int
+	OpenReports     int
+	Growth          []growthDay
+}
+
+type growthDay struct {
+	Day      time.Time
+	Users    int
+	Snippets int
+}
+
+func (a *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	stats := &adminStats{}
+	var err error
+
+	stats.Users, stats.DisabledUsers, err = a.users.Counts()
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	stats.ActiveSnippets, stats.ExpiredSnippets, err = a.snippets.Counts()
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	stats.OpenReports, err = a.reports.CountOpen()
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	users, err := a.users.CreatedPerDay(growthDays)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	snippets, err := a.snippets.CreatedPerDay(growthDays)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	stats.Growth = dailyGrowth(time.Now().UTC(), growthDays, users, snippets)
+
+	data := a.NewTemplateData(r)
+	data.Stats = stats
+	a.render(w, r, http.StatusOK, "admin/dashboard", data)
+}
+
+func (a *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	users, err := a.users.Search(query, adminListLimit)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.NewTemplateData(r)
+	data.Users = users
+	data.Roles = models.Roles
+	data.Query = query
+	a.render(w, r, http.StatusOK, "admin/users", data)
+}
+
+func (a *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFound(w, r)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	role := r.PostForm.Get("role")
+	if !models.ValidRole(role) {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// admins can't demote themselves, otherwise the last admin could lock everyone out of these pages
+	if id == a.authenticatedUser(r).ID {
+		a.sessionManager.Put(r.Context(), "flash", "You can't change your own role.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = a.users.SetRole(id, role)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("User #%d is now a %s.", id, role))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (a *application) adminUserSetDisabledPost(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.readIDParam(r)
+		if err != nil {
+			a.notFound(w, r)
+			return
+		}
+
+		if id == a.authenticatedUser(r).ID {
+			a.sessionManager.Put(r.Context(), "flash", "You can't disable your own account.")
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+			return
+		}
+
+		err = a.users.SetDisabled(id, disabled)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				a.notFound(w, r)
+			} else {
+				a.serverError(w, r, err)
+			}
+			return
+		}
+
+		message := fmt.Sprintf("User #%d has been enabled.", id)
+		if disabled {
+			// log the user out everywhere right away instead of waiting for their next request
+			err = a.userSessions.DeleteAllForUser(id, "")
+			if err != nil {
+				a.serverError(w, r, err)
+				return
+			}
+			err = a.tokens.DeleteAllForUser(models.ScopeAuthentication, id)
+			if err != nil {
+				a.serverError(w, r, err)
+				return
+			}
+			message = fmt.Sprintf("User #%d has been disabled.", id)
+		}
+
+		a.sessionManager.Put(r.Context(), "flash", message)
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	}
+}
+
+func (a *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	snippets, err := a.snippets.Search(query, adminListLimit)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.NewTemplateData(r)
+	data.Snippets = snippets
+	data.Query = query
+	a.render(w, r, http.StatusOK, "admin/snippets", data)
+}
+
+func (a *application) adminSnippetExtendPost(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFound(w, r)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	days, err := strconv.Atoi(r.PostForm.Get("days"))
+	if err != nil || !validator.PermittedValue(days, 1, 7, 365) {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = a.snippets.ExtendExpiry(id, days)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Snippet #%d has been extended by %d days.", id, days))
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+func (a *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFound(w, r)
+		return
+	}
+
+	err = a.snippets.DeleteByID(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Snippet #%d has been deleted.", id))
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+func (a *application) adminReports(w http.ResponseWriter, r *http.Request) {
+	reports, err := a.reports.Open()
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.NewTemplateData(r)
+	data.Reports = reports
+	a.render(w, r, http.StatusOK, "admin/reports", data)
+}
+
+// adminReportDismissPost - keeps the snippet and resolves every report about it
+func (a *application) adminReportDismissPost(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFound(w, r)
+		return
+	}
+
+	err = a.reports.Resolve(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "The report has been dismissed.")
+	http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+}
+
+// adminReportDeleteSnippetPost - deletes the reported snippet, its reports are deleted along with it
+func (a *application) adminReportDeleteSnippetPost(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFound(w, r)
+		return
+	}
+
+	report, err := a.reports.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = a.snippets.DeleteByID(report.SnippetID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Snippet #%d has been deleted.", report.SnippetID))
+	http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+}
+
+// dailyGrowth - merges the signups and new snippets per day into one row for each of the last days up to now,
+// days without any activity are included with zero counts
+func dailyGrowth(now time.Time, days int, users, snippets []models.DailyCount) []growthDay {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	growth := make([]growthDay, days)
+	index := map[string]*growthDay{}
+	for i := range growth {
+		growth[i].Day = today.AddDate(0, 0, i-days+1)
+		index[growth[i].Day.Format("2006-01-02")] = &growth[i]
+	}
+
+	for _, c := range users {
+		if g, ok := index[c.Day.Format("2006-01-02")]; ok {
+			g.Users = c.Count
+		}
+	}
+	for _, c := range snippets {
+		if g, ok := index[c.Day.Format("2006-01-02")]; ok {
+			g.Snippets = c.Count
+		}
+	}
+
+	return growth
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/validator"
+	"github.com/julienschmidt/httprouter"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiTokenTTL is how long a token issued to the command-line client stays valid.
+const apiTokenTTL = 30 * 24 * time.Hour
+
+type envelope map[string]any
+
+type apiSnippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	URL     string    `json:"url"`
+}
+
+type apiTokenInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	// Code is only required when the account has two-factor authentication enabled
+	Code                string `json:"code"`
+	validator.Validator `json:"-"`
+}
+
+type apiSnippetInput struct {
+	Title               string `json:"title"`
+	Content             string `json:"content"`
+	Expires             int    `json:"expires"`
+	validator.Validator `json:"-"`
+}
+
+func (a *application) apiCreateToken(w http.ResponseWriter, r *http.Request) {
+	var input apiTokenInput
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	input.CheckField(validator.NotBlank(input.Email), "email", "This field cannot be blank")
+	input.CheckField(validator.Matches(input.Email, validator.EmailRx), "email", "This field must be a valid email address")
+	input.CheckField(validator.NotBlank(input.Password), "password", "This field cannot be blank")
+
+	if input.Invalid() {
+		a.apiValidationError(w, input.Validator)
+		return
+	}
+
+	accountKey := "email:" + strings.ToLower(input.Email)
+	lockedFor, err := a.loginAttempt(r, accountKey)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+	if lockedFor > 0 {
+		w.Header().Set("Retry-After", retryAfter(lockedFor))
+		a.apiError(w, http.StatusTooManyRequests, "too many failed login attempts, please try again later")
+		return
+	}
+
+	id, err := a.users.Authenticate(input.Email, input.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = a.loginFailed(r, accountKey)
+			if err != nil {
+				a.apiServerError(w, r, err)
+				return
+			}
+			a.apiError(w, http.StatusUnauthorized, "Email or password is incorrect")
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	ok, err := a.checkSecondFactor(id, input.Code)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+	if !ok {
+		err = a.loginFailed(r, accountKey)
+		if err != nil {
+			a.apiServerError(w, r, err)
+			return
+		}
+		a.apiError(w, http.StatusUnauthorized, "a valid two-factor authentication code is required")
+		return
+	}
+
+	err = a.loginSucceeded(r, accountKey)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	token, err := a.tokens.New(id, apiTokenTTL, models.ScopeAuthentication)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	a.writeJSON(w, http.StatusCreated, envelope{"token": token.Plaintext, "expiry": token.Expiry})
+}
+
+// apiDeleteToken - revokes the token the request was authenticated with, used to log out
+func (a *application) apiDeleteToken(w http.ResponseWriter, r *http.Request) {
+	// authenticateToken already checked the header
+	_, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+
+	err := a.tokens.Delete(models.ScopeAuthentication, token)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	snippets, err := a.snippets.Latest()
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	list := make([]apiSnippet, 0, len(snippets))
+	for _, snippet := range snippets {
+		s := a.newAPISnippet(r, snippet)
+		s.Content = ""
+		list = append(list, s)
+	}
+
+	a.writeJSON(w, http.StatusOK, envelope{"snippets": list})
+}
+
+func (a *application) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	snippet, err := a.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	a.metrics.snippetViews.With("api").Inc()
+	a.writeJSON(w, http.StatusOK, envelope{"snippet": a.newAPISnippet(r, snippet)})
+}
+
+func (a *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	user, err := a.users.Get(a.apiUserID(r))
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+	if !user.EmailVerified() {
+		a.apiError(w, http.StatusForbidden, "verify your email address before publishing snippets")
+		return
+	}
+
+	var input apiSnippetInput
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	input.CheckField(validator.NotBlank(input.Title), "title", "This field cannot be blank.")
+	input.CheckField(validator.MaxChars(input.Title, 100), "title", "This field can not be more than 100 characters long.")
+	input.CheckField(validator.NotBlank(input.Content), "content", "This field cannot be blank.")
+	input.CheckField(validator.PermittedValue(input.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+
+	if input.Invalid() {
+		a.apiValidationError(w, input.Validator)
+		return
+	}
+
+	id, err := a.snippets.Insert(a.apiUserID(r), input.Title, input.Content, input.Expires)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+	a.metrics.snippetsCreated.With("api").Inc()
+
+	snippet, err := a.snippets.Get(id)
+	if err != nil {
+		a.apiServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/snippets/%d", id))
+	a.writeJSON(w, http.StatusCreated, envelope{"snippet": a.newAPISnippet(r, snippet)})
+}
+
+func (a *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	}
+
+	err = a.snippets.Delete(id, a.apiUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		} else {
+			a.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *application) newAPISnippet(r *http.Request, snippet *models.Snippet) apiSnippet {
+	return apiSnippet{
+		ID:      snippet.ID,
+		Title:   snippet.Title,
+		Content: snippet.Content,
+		Created: snippet.Created,
+		Expires: snippet.Expires,
+		URL:     fmt.Sprintf("https://%s/snippet/view/%d", r.Host, snippet.ID),
+	}
+}
+
+func (a *application) readIDParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid id parameter")
+	}
+
+	return id, nil
+}
+
+func (a *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	// limit the size of the request body to 1MB
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return fmt.Errorf("body contains badly-formed JSON: %w", err)
+	}
+
+	// the body must only contain a single JSON value
+	if err = dec.Decode(&struct{}{}); err != io.EOF {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+func (a *application) writeJSON(w http.ResponseWriter, status int, data envelope) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		a.logger.Error("encoding JSON response failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+func (a *application) apiError(w http.ResponseWriter, status int, message string) {
+	a.writeJSON(w, status, envelope{"error": message})
+}
+
+func (a *application) apiValidationError(w http.ResponseWriter, v validator.Validator) {
+	a.writeJSON(w, http.StatusUnprocessableEntity, envelope{"error": v.FieldErrors})
+}
+
+func (a *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	a.logError(r, err, 1)
+	a.writeJSON(w, http.StatusInternalServerError, envelope{
+		"error":      http.StatusText(http.StatusInternalServerError),
+		"request_id": getRequestID(r),
+	})
+}
+
+func (a *application) apiUserID(r *http.Request) int {
+	id, ok := r.Context().Value(apiUserIDContextKey).(int)
+	if !ok {
+		return 0
+	}
+	return id
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/compress"
+	"github.com/julienschmidt/httprouter"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// assetTable - maps the static files to names containing a hash of their content, e.g. css/main.css
+// to css/main.3f9a1c2b.css. A new version of a file gets a new URL, so the hashed URLs can be cached forever.
+// Files that compress well are also kept gzipped, so they don't have to be compressed on every request.
+type assetTable struct {
+	hashed   map[string]string
+	original map[string]string
+	gzipped  map[string][]byte
+	// version is a hash of all templates and static files, it changes with every release that changes the UI
+	version string
+}
+
+// newAssetTable - hashes every file below static/ in fsys and gzips the ones that compress well
+func newAssetTable(fsys fs.FS) (*assetTable, error) {
+	t := &assetTable{hashed: map[string]string{}, original: map[string]string{}, gzipped: map[string][]byte{}}
+	version := sha256.New()
+
+	// WalkDir visits the files in lexical order, so the version does not depend on the file system
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(b)
+		fmt.Fprintf(version, "%s %x\n", name, sum)
+		if !strings.HasPrefix(name, "static/") {
+			return nil
+		}
+		name = strings.TrimPrefix(name, "static/")
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+
+		t.hashed[name] = hashed
+		t.original[hashed] = name
+
+		mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+		if slices.Contains(compress.DefaultContentTypes, mediaType) {
+			var buf bytes.Buffer
+			gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			if err != nil {
+				return err
+			}
+			gz.Write(b)
+			err = gz.Close()
+			if err != nil {
+				return err
+			}
+			if buf.Len() < len(b) {
+				t.gzipped[name] = buf.Bytes()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t.version = hex.EncodeToString(version.Sum(nil))
+	return t, nil
+}
+
+// url - the asset template function, returns the URL of the static file name, e.g. {{ asset "css/main.css" }}.
+// Unknown files and a nil table, which is used in -dev mode, give the URL without a hash.
+func (t *assetTable) url(name string) string {
+	if t != nil {
+		if hashed, ok := t.hashed[name]; ok {
+			return "/static/" + hashed
+		}
+	}
+	return "/static/" + name
+}
+
+// gzip - returns the precompressed content of the static file name
+func (t *assetTable) gzip(name string) ([]byte, bool) {
+	if t == nil {
+		return nil, false
+	}
+	b, ok := t.gzipped[name]
+	return b, ok
+}
+
+// staticFiles - serves the files below ui/static, hashed URLs are marked as immutable and directories are not listed.
+// Clients accepting gzip get the precompressed version if there is one.
+func (a *application) staticFiles() http.Handler {
+	fileServer := http.FileServer(http.FS(a.uiFiles))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(httprouter.ParamsFromContext(r.Context()).ByName("filepath")), "/")
+
+		if a.assets != nil {
+			if original, ok := a.assets.original[name]; ok {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+				name = original
+			}
+		}
+
+		if gz, ok := a.assets.gzip(name); ok {
+			if !slices.Contains(w.Header().Values("Vary"), "Accept-Encoding") {
+				w.Header().Add("Vary", "Accept-Encoding")
+			}
+			if _, ok := compress.Negotiate(r, []compress.Encoder{compress.Gzip}); ok {
+				w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+				w.Header().Set("Content-Encoding", "gzip")
+				http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(gz))
+				return
+			}
+		}
+
+		info, err := fs.Stat(a.uiFiles, "static/"+name)
+		if err != nil || info.IsDir() {
+			a.notFound(w, r)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		r.URL.Path = "/static/" + name
+		r.URL.RawPath = ""
+		fileServer.ServeHTTP(w, r)
+	})
+}
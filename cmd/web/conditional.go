@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/justinas/nosurf"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// notModified - sets the validators of the response and answers with 304 if the client's copy is still current,
+// a zero modified leaves out Last-Modified. Returns true if the response was written.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence and uses the weak comparison, the compression middleware
+	// turns the ETag into a weak one when it compresses the response
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				writeNotModified(w)
+				return true
+			}
+		}
+		return false
+	}
+
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !modified.IsZero() {
+		since, err := http.ParseTime(header)
+		// Last-Modified only has second precision
+		if err == nil && !modified.Truncate(time.Second).After(since) {
+			writeNotModified(w)
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeNotModified - browsers update the stored headers with the ones of a 304 response, the CSP must keep the nonce
+// that is in the cached page or its inline scripts and styles would be blocked
+func writeNotModified(w http.ResponseWriter) {
+	w.Header().Del("Content-Security-Policy")
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// snippetPageETag - identifies the HTML of the snippet page, which depends on the snippet, the release of the UI,
+// the user looking at it and the CSRF cookie the tokens in its forms are checked against.
+// It returns "" in -dev mode, where the templates can change at any time.
+func (a *application) snippetPageETag(r *http.Request, snippet *models.Snippet) string {
+	if a.assets == nil {
+		return ""
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%d\n%q\n%q\n%d\n%d\n%d\n", a.assets.version, time.Now().Year(),
+		snippet.ID, snippet.Title, snippet.Content, snippet.UserID, snippet.Created.Unix(), snippet.Expires.Unix())
+	if user := a.authenticatedUser(r); user != nil {
+		fmt.Fprintf(h, "%d\n%s\n", user.ID, user.Role)
+	}
+	if cookie, err := r.Cookie(nosurf.CookieName); err == nil {
+		fmt.Fprintf(h, "%s\n", cookie.Value)
+	}
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// snippetRawETag - the raw page only contains the content
+func snippetRawETag(snippet *models.Snippet) string {
+	sum := sha256.Sum256([]byte(snippet.Content))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
@@ -2,4 +2,8 @@ package main
 
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const authenticatedUserContextKey = contextKey("authenticatedUser")
+const apiUserIDContextKey = contextKey("apiUserID")
+const requestIDContextKey = contextKey("requestID")
+const routePatternContextKey = contextKey("routePattern")
+const cspNonceContextKey = contextKey("cspNonce")
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// defaultCSP - only resources from our own origin are allowed, inline scripts and styles need the nonce of the request
+const defaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; " +
+	"img-src 'self'; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'; " +
+	"report-uri /csp-report; report-to csp"
+
+// defaultPermissionsPolicy - the site uses none of the powerful browser features
+const defaultPermissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=(), usb=()"
+
+// securityHeaders - the configurable part of the headers set by secureHeaders
+type securityHeaders struct {
+	// csp is the Content-Security-Policy, {nonce} is replaced with the nonce of the request
+	csp               string
+	permissionsPolicy string
+	// hstsMaxAge of 0 leaves out Strict-Transport-Security
+	hstsMaxAge time.Duration
+}
+
+// cspNonce - the nonce that inline scripts and styles of the response must carry
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceContextKey).(string)
+	return nonce
+}
+
+// maxCSPReportSize - reports are small, anything bigger is not a report
+const maxCSPReportSize = 64 << 10
+
+// cspReport - logs the violations browsers report. It accepts the application/csp-report format of report-uri
+// and the application/reports+json format of the Reporting API used by report-to.
+func (a *application) cspReport(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReportSize))
+	if err != nil {
+		a.clientError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	type violation struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+	}
+	var violations []violation
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/reports+json":
+		var reports []struct {
+			Type string `json:"type"`
+			Body struct {
+				DocumentURL        string `json:"documentURL"`
+				BlockedURL         string `json:"blockedURL"`
+				EffectiveDirective string `json:"effectiveDirective"`
+				SourceFile         string `json:"sourceFile"`
+				LineNumber         int    `json:"lineNumber"`
+			} `json:"body"`
+		}
+		err = json.Unmarshal(body, &reports)
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			violations = append(violations, violation{
+				DocumentURI:        report.Body.DocumentURL,
+				BlockedURI:         report.Body.BlockedURL,
+				EffectiveDirective: report.Body.EffectiveDirective,
+				SourceFile:         report.Body.SourceFile,
+				LineNumber:         report.Body.LineNumber,
+			})
+		}
+	default:
+		var report struct {
+			Report violation `json:"csp-report"`
+		}
+		err = json.Unmarshal(body, &report)
+		violations = append(violations, report.Report)
+	}
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	for _, v := range violations {
+		directive := v.EffectiveDirective
+		if directive == "" {
+			directive = v.ViolatedDirective
+		}
+		a.logger.LogAttrs(r.Context(), slog.LevelWarn, "csp violation",
+			slog.String("document_uri", v.DocumentURI),
+			slog.String("blocked_uri", v.BlockedURI),
+			slog.String("directive", directive),
+			slog.String("source_file", v.SourceFile),
+			slog.Int("line", v.LineNumber),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// devTemplates - the template cache of -dev mode, the templates are read from disk and parsed again
+// whenever a file or directory below html/ has been modified since the last parse.
+// Static files are linked without hashes since they change while the server runs.
+type devTemplates struct {
+	fsys    fs.FS
+	mu      sync.Mutex
+	modTime time.Time
+	cache   map[string]*template.Template
+}
+
+func (d *devTemplates) get() (map[string]*template.Template, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// directories are included so that deleted and renamed files are noticed as well
+	var latest time.Time
+	err := fs.WalkDir(d.fsys, "html", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if d.cache == nil || latest.After(d.modTime) {
+		cache, err := NewTemplateCache(d.fsys, nil)
+		if err != nil {
+			return nil, err
+		}
+		d.cache = cache
+		d.modTime = latest
+	}
+
+	return d.cache, nil
+}
+
+// noCache - keeps browsers from caching static files in -dev mode so changes show up on the next reload
+func noCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -3,11 +3,28 @@ package main
 import (
 	"errors"
 	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/mailer"
 	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/totp"
 	"github.com/danyelkeddah/snippetbox/internal/validator"
 	"github.com/julienschmidt/httprouter"
+	"github.com/skip2/go-qrcode"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// passwordResetTTL is how long a password reset link can be used.
+	passwordResetTTL = 45 * time.Minute
+	// emailVerificationTTL is how long the link sent after signup stays valid.
+	emailVerificationTTL = 72 * time.Hour
+	// emailVerificationThrottle is the minimum time between two verification emails to the same user.
+	emailVerificationThrottle = 5 * time.Minute
+	// twoFactorLoginTTL is how long a user has to enter their code after the password was accepted.
+	twoFactorLoginTTL = 5 * time.Minute
 )
 
 type SnippetCreateForm struct {
@@ -17,6 +34,11 @@ type SnippetCreateForm struct {
 	validator.Validator `form:"-"`
 }
 
+type SnippetReportForm struct {
+	Reason              string `form:"reason"`
+	validator.Validator `form:"-"`
+}
+
 type UserSignupForm struct {
 	Name                string `form:"name"`
 	Email               string `form:"email"`
@@ -27,34 +49,70 @@ type UserSignupForm struct {
 type UserLoginForm struct {
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	RememberMe          bool   `form:"rememberMe"`
+	validator.Validator `form:"-"`
+}
+
+type TwoFactorForm struct {
+	Code                string `form:"code"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type TwoFactorDisableForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+type PasswordResetRequestForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+type PasswordResetForm struct {
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+type AccountDeleteForm struct {
+	Password            string `form:"password"`
+	Snippets            string `form:"snippets"`
 	validator.Validator `form:"-"`
 }
 
+type AccountPasswordUpdateForm struct {
+	CurrentPassword         string `form:"currentPassword"`
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
 func (a *application) home(w http.ResponseWriter, r *http.Request) {
 	snippets, err := a.snippets.Latest()
 	if err != nil {
-		a.serverError(w, err)
+		a.serverError(w, r, err)
 		return
 	}
 
 	data := a.NewTemplateData(r)
 	data.Snippets = snippets
-	a.render(w, http.StatusOK, "home", data)
+	a.render(w, r, http.StatusOK, "home", data)
 }
 
 func (a *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil || id < 1 {
-		a.notFound(w)
+		a.notFound(w, r)
 		return
 	}
 	snippet, err := a.snippets.Get(id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			a.notFound(w)
+			a.notFound(w, r)
 		} else {
-			a.serverError(w, err)
+			a.serverError(w, r, err)
 		}
 		return
 	}
@@ -62,9 +120,143 @@ func (a *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	// Get the value and delete it from cache (acts like on time fetch),
 	// If there is no matching key in session it will return empty string.
 
+	a.metrics.snippetViews.With("web").Inc()
+
+	// a page with a flash message is only shown once, so it must neither be answered with 304 nor revalidated later
+	if etag := a.snippetPageETag(r, snippet); etag != "" && !a.sessionManager.Exists(r.Context(), "flash") {
+		// the page of a logged-in user depends on more than the snippet, so only the ETag can tell if it changed
+		modified := snippet.Updated
+		if a.IsAuthenticated(r) {
+			modified = time.Time{}
+			w.Header().Set("Cache-Control", "private, no-cache")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		if notModified(w, r, etag, modified) {
+			return
+		}
+	}
+
 	data := a.NewTemplateData(r)
 	data.Snippet = snippet
-	a.render(w, http.StatusOK, "view", data)
+	data.CanDeleteSnippet = canDeleteSnippet(data.AuthenticatedUser, snippet)
+	a.render(w, r, http.StatusOK, "view", data)
+}
+
+// snippetRaw - the content of the snippet as plain text
+func (a *application) snippetRaw(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFound(w, r)
+		return
+	}
+	snippet, err := a.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	a.metrics.snippetViews.With("raw").Inc()
+
+	// the snippet may be deleted at any time, so clients have to revalidate their copy
+	w.Header().Set("Cache-Control", "no-cache")
+	if notModified(w, r, snippetRawETag(snippet), snippet.Updated) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, snippet.Content)
+}
+
+func (a *application) snippetReportPost(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFound(w, r)
+		return
+	}
+
+	snippet, err := a.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	var form SnippetReportForm
+	err = a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Reason), "reason", "Please tell us what is wrong with this snippet")
+	form.CheckField(validator.MaxChars(form.Reason, 500), "reason", "This field cannot be more than 500 characters long")
+
+	if !form.Valid() {
+		data := a.NewTemplateData(r)
+		data.Snippet = snippet
+		data.CanDeleteSnippet = canDeleteSnippet(data.AuthenticatedUser, snippet)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "view", data)
+		return
+	}
+
+	err = a.reports.Insert(id, a.authenticatedUser(r).ID, form.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrConflict):
+			a.sessionManager.Put(r.Context(), "flash", "You already reported this snippet.")
+			http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+		case errors.Is(err, models.ErrNoRecord):
+			// the snippet was deleted in the meantime
+			a.notFound(w, r)
+		default:
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Thanks, a moderator will review the snippet.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
+func (a *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFound(w, r)
+		return
+	}
+
+	snippet, err := a.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	if !canDeleteSnippet(a.authenticatedUser(r), snippet) {
+		a.clientError(w, r, http.StatusForbidden)
+		return
+	}
+
+	err = a.snippets.DeleteByID(id)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Snippet successfully deleted!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (a *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
@@ -72,14 +264,14 @@ func (a *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	data.Form = SnippetCreateForm{
 		Expires: 365,
 	}
-	a.render(w, http.StatusOK, "create", data)
+	a.render(w, r, http.StatusOK, "create", data)
 }
 
 func (a *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
 	var form SnippetCreateForm
 	err := a.decodePostForm(r, &form)
 	if err != nil {
-		a.clientError(w, http.StatusBadRequest)
+		a.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -91,15 +283,17 @@ func (a *application) snippetCreatePost(w http.ResponseWriter, r *http.Request)
 	if !form.Valid() {
 		data := a.NewTemplateData(r)
 		data.Form = form
-		a.render(w, http.StatusUnprocessableEntity, "create", data)
+		a.render(w, r, http.StatusUnprocessableEntity, "create", data)
 		return
 	}
 
-	id, err := a.snippets.Insert(form.Title, form.Content, form.Expires)
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	id, err := a.snippets.Insert(userID, form.Title, form.Content, form.Expires)
 	if err != nil {
-		a.serverError(w, err)
+		a.serverError(w, r, err)
 		return
 	}
+	a.metrics.snippetsCreated.With("web").Inc()
 
 	a.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
 
@@ -109,14 +303,14 @@ func (a *application) snippetCreatePost(w http.ResponseWriter, r *http.Request)
 func (a *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	data := a.NewTemplateData(r)
 	data.Form = UserSignupForm{}
-	a.render(w, http.StatusOK, "signup", data)
+	a.render(w, r, http.StatusOK, "signup", data)
 }
 
 func (a *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	var form UserSignupForm
 	err := a.decodePostForm(r, &form)
 	if err != nil {
-		a.clientError(w, http.StatusBadRequest)
+		a.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
@@ -128,24 +322,30 @@ func (a *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	if form.Invalid() {
 		data := a.NewTemplateData(r)
 		data.Form = form
-		a.render(w, http.StatusUnprocessableEntity, "signup", data)
+		a.render(w, r, http.StatusUnprocessableEntity, "signup", data)
 		return
 	}
 
-	err = a.users.Insert(form.Name, form.Email, form.Password)
+	id, err := a.users.Insert(form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email address ia already in use")
 			data := a.NewTemplateData(r)
 			data.Form = form
-			a.render(w, http.StatusUnprocessableEntity, "signup", data)
+			a.render(w, r, http.StatusUnprocessableEntity, "signup", data)
 			return
 		} else {
-			a.serverError(w, err)
+			a.serverError(w, r, err)
 		}
 		return
 	}
-	a.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
+
+	err = a.sendVerificationEmail(&models.User{ID: id, Name: form.Name, Email: form.Email})
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please check your email to verify your address, then log in.")
 
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
@@ -154,7 +354,7 @@ func (a *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := a.NewTemplateData(r)
 	data.Form = UserLoginForm{}
 
-	a.render(w, http.StatusOK, "login", data)
+	a.render(w, r, http.StatusOK, "login", data)
 }
 
 func (a *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -162,7 +362,7 @@ func (a *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	err := a.decodePostForm(r, &form)
 
 	if err != nil {
-		a.clientError(w, http.StatusBadRequest)
+		a.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -173,42 +373,689 @@ func (a *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	if form.Invalid() {
 		data := a.NewTemplateData(r)
 		data.Form = form
-		a.render(w, http.StatusUnprocessableEntity, "login", data)
+		a.render(w, r, http.StatusUnprocessableEntity, "login", data)
 
 		return
 	}
+
+	accountKey := "email:" + strings.ToLower(form.Email)
+	lockedFor, err := a.loginAttempt(r, accountKey)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if lockedFor > 0 {
+		// the same message is shown whether the password would have been right or not
+		form.AddNonFieldError("Too many failed login attempts. Please try again later.")
+		data := a.NewTemplateData(r)
+		data.Form = form
+		w.Header().Set("Retry-After", retryAfter(lockedFor))
+		a.render(w, r, http.StatusTooManyRequests, "login", data)
+		return
+	}
+
 	id, err := a.users.Authenticate(form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = a.loginFailed(r, accountKey)
+			if err != nil {
+				a.serverError(w, r, err)
+				return
+			}
 			form.AddNonFieldError("Email or password is incorrect")
 			data := a.NewTemplateData(r)
 			data.Form = form
-			a.render(w, http.StatusUnprocessableEntity, "login", data)
+			a.render(w, r, http.StatusUnprocessableEntity, "login", data)
 		} else {
-			a.serverError(w, err)
+			a.serverError(w, r, err)
 		}
 		return
 	}
-	// regenerate user session
-	err = a.sessionManager.RenewToken(r.Context()) // will change the id of the current user session retain the data
+
+	err = a.loginSucceeded(r, accountKey)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.loginWithSecondFactor(w, r, id, form.RememberMe)
+}
+
+func (a *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if a.pendingTwoFactorUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := a.NewTemplateData(r)
+	data.Form = TwoFactorForm{}
+	a.render(w, r, http.StatusOK, "login_2fa", data)
+}
+
+func (a *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	id := a.pendingTwoFactorUserID(r)
+	if id == 0 {
+		a.sessionManager.Put(r.Context(), "flash", "Your login attempt has expired, please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form TwoFactorForm
+	err := a.decodePostForm(r, &form)
 	if err != nil {
-		a.serverError(w, err)
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	// a blank code is not an attempt, so it is rejected before anything is counted
+	if form.Valid() {
+		// codes are short, so guessing them is throttled just like passwords
+		accountKey := fmt.Sprintf("2fa:%d", id)
+		lockedFor, err := a.loginAttempt(r, accountKey)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+		if lockedFor > 0 {
+			form.AddNonFieldError("Too many failed attempts. Please try again later.")
+			data := a.NewTemplateData(r)
+			data.Form = form
+			w.Header().Set("Retry-After", retryAfter(lockedFor))
+			a.render(w, r, http.StatusTooManyRequests, "login_2fa", data)
+			return
+		}
+
+		ok, err := a.checkSecondFactor(id, form.Code)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+		if ok {
+			err = a.loginSucceeded(r, accountKey)
+		} else {
+			err = a.loginFailed(r, accountKey)
+			form.AddFieldError("code", "This code is not valid")
+		}
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+	}
+
+	if form.Invalid() {
+		data := a.NewTemplateData(r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "login_2fa", data)
 		return
 	}
-	// set id in user session
-	a.sessionManager.Put(r.Context(), "authenticatedUserID", id)
 
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	rememberMe := a.sessionManager.PopBool(r.Context(), "pendingTwoFactorRememberMe")
+	a.sessionManager.Remove(r.Context(), "pendingTwoFactorUserID")
+	a.sessionManager.Remove(r.Context(), "pendingTwoFactorDeadline")
+	a.completeLogin(w, r, id, rememberMe)
 }
 
 func (a *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	err := a.sessionManager.RenewToken(r.Context())
+	err := a.logoutSession(r)
 	if err != nil {
-		a.serverError(w, err)
+		a.serverError(w, r, err)
 		return
 	}
-	a.sessionManager.Remove(r.Context(), "authenticatedUserID")
 	a.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+func (a *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	data := a.NewTemplateData(r)
+	data.Form = PasswordResetRequestForm{}
+	a.render(w, r, http.StatusOK, "reset_request", data)
+}
+
+func (a *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var form PasswordResetRequestForm
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRx), "email", "This field must be a valid email address")
+
+	if form.Invalid() {
+		data := a.NewTemplateData(r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "reset_request", data)
+		return
+	}
+
+	// the response is the same whether the account exists or not, so it can not be used to discover email addresses
+	flash := "If an account with that email exists, we've sent a link to reset the password."
+
+	user, err := a.users.GetByEmail(form.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.sessionManager.Put(r.Context(), "flash", flash)
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	if !user.Disabled {
+		token, err := a.tokens.New(user.ID, passwordResetTTL, models.ScopePasswordReset)
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+
+		a.sendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Reset your Snippetbox password",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your Snippetbox account.\n"+
+				"Open the link below within %d minutes to choose a new one:\n\n%s/user/password/reset/%s\n\n"+
+				"If it wasn't you, you can ignore this email.\n",
+				user.Name, int(passwordResetTTL.Minutes()), a.baseURL, token.Plaintext),
+		})
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (a *application) userPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	_, err := a.tokens.UserID(models.ScopePasswordReset, token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/password/reset", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := a.NewTemplateData(r)
+	data.Form = PasswordResetForm{}
+	data.Token = token
+	a.render(w, r, http.StatusOK, "reset", data)
+}
+
+func (a *application) userPasswordResetConfirmPost(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	var form PasswordResetForm
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := a.tokens.UserID(models.ScopePasswordReset, token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/password/reset", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
+
+	if form.Invalid() {
+		data := a.NewTemplateData(r)
+		data.Form = form
+		data.Token = token
+		a.render(w, r, http.StatusUnprocessableEntity, "reset", data)
+		return
+	}
+
+	// reset links are single use, consuming the link first lets only one of several concurrent requests through
+	err = a.tokens.Consume(models.ScopePasswordReset, token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.sessionManager.Put(r.Context(), "flash", "That password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/password/reset", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = a.users.UpdatePassword(userID, form.NewPassword)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// other reset links are void as well, and whoever knew the old password must not stay logged in
+	err = a.tokens.DeleteAllForUser(models.ScopePasswordReset, userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	err = a.tokens.DeleteAllForUser(models.ScopeAuthentication, userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	err = a.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	err = a.userSessions.DeleteAllForUser(userID, "")
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	a.sessionManager.Remove(r.Context(), "sessionID")
+
+	a.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (a *application) userVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	userID, err := a.tokens.UserID(models.ScopeEmailVerification, token)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.sessionManager.Put(r.Context(), "flash", "That verification link is invalid or has expired.")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = a.users.MarkEmailVerified(userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	err = a.tokens.DeleteAllForUser(models.ScopeEmailVerification, userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Your email address has been verified!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (a *application) userVerifyEmailResendPost(w http.ResponseWriter, r *http.Request) {
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := a.users.Get(userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	if user.EmailVerified() {
+		a.sessionManager.Put(r.Context(), "flash", "Your email address is already verified.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	lastSent, err := a.tokens.LastCreated(models.ScopeEmailVerification, user.ID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if time.Since(lastSent) < emailVerificationThrottle {
+		a.sessionManager.Put(r.Context(), "flash", "We've sent you a verification email recently, please wait a few minutes before asking for another one.")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	err = a.sendVerificationEmail(user)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "A new verification email is on its way.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (a *application) accountView(w http.ResponseWriter, r *http.Request) {
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := a.users.Get(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := a.NewTemplateData(r)
+	data.User = user
+	a.render(w, r, http.StatusOK, "account", data)
+}
+
+func (a *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	data := a.NewTemplateData(r)
+	data.Form = AccountPasswordUpdateForm{}
+	a.render(w, r, http.StatusOK, "password", data)
+}
+
+func (a *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form AccountPasswordUpdateForm
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be at least 8 characters long")
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "This field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
+
+	if form.Invalid() {
+		data := a.NewTemplateData(r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "password", data)
+		return
+	}
+
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err = a.users.PasswordUpdate(userID, form.CurrentPassword, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("currentPassword", "Current password is incorrect")
+			data := a.NewTemplateData(r)
+			data.Form = form
+			a.render(w, r, http.StatusUnprocessableEntity, "password", data)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	// the current session gets a new token, every other session of the user is logged out
+	err = a.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	err = a.userSessions.DeleteAllForUser(userID, a.sessionManager.GetString(r.Context(), "sessionID"))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	err = a.tokens.DeleteAllForUser(models.ScopeAuthentication, userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (a *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	data := a.NewTemplateData(r)
+	data.Form = AccountDeleteForm{Snippets: "delete"}
+	a.render(w, r, http.StatusOK, "account_delete", data)
+}
+
+func (a *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form AccountDeleteForm
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	form.CheckField(validator.PermittedValue(form.Snippets, "delete", "anonymize"), "snippets", "Please choose what happens to your snippets")
+
+	if form.Invalid() {
+		data := a.NewTemplateData(r)
+		data.Form = form
+		a.render(w, r, http.StatusUnprocessableEntity, "account_delete", data)
+		return
+	}
+
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err = a.users.VerifyPassword(userID, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("password", "Password is incorrect")
+			data := a.NewTemplateData(r)
+			data.Form = form
+			a.render(w, r, http.StatusUnprocessableEntity, "account_delete", data)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	// every other session is logged out because its session record is deleted together with the user
+	err = a.users.Delete(userID, form.Snippets == "delete")
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.snippets.ForgetUser(userID)
+
+	err = a.logoutSession(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.sessionManager.Put(r.Context(), "flash", "Your account has been deleted.")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (a *application) accountTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	// a new secret is generated each time the page is shown, it is only stored for the user once a code confirms it
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.sessionManager.Put(r.Context(), "twoFactorEnrollmentSecret", secret)
+
+	data := a.NewTemplateData(r)
+	data.Form = TwoFactorForm{}
+	data.TwoFactorSecret = secret
+	a.render(w, r, http.StatusOK, "twofactor_enroll", data)
+}
+
+func (a *application) accountTwoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	secret := a.sessionManager.GetString(r.Context(), "twoFactorEnrollmentSecret")
+	if secret == "" {
+		a.notFound(w, r)
+		return
+	}
+
+	user, err := a.users.Get(a.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URL("Snippetbox", user.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+func (a *application) accountTwoFactorEnrollPost(w http.ResponseWriter, r *http.Request) {
+	secret := a.sessionManager.GetString(r.Context(), "twoFactorEnrollmentSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/account/2fa/enroll", http.StatusSeeOther)
+		return
+	}
+
+	var form TwoFactorForm
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+	step, ok := totp.Match(secret, form.Code, time.Now())
+	form.CheckField(ok, "code", "This code is not valid, check the time on your device and try again")
+
+	// replacing an enabled second factor needs the password, like disabling it, so a stolen session can't take it over
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if a.authenticatedUser(r).TwoFactorEnabled {
+		err = a.users.VerifyPassword(userID, form.Password)
+		if err != nil && !errors.Is(err, models.ErrInvalidCredentials) {
+			a.serverError(w, r, err)
+			return
+		}
+		form.CheckField(err == nil, "password", "Password is incorrect")
+	}
+
+	if form.Invalid() {
+		data := a.NewTemplateData(r)
+		data.Form = form
+		data.TwoFactorSecret = secret
+		a.render(w, r, http.StatusUnprocessableEntity, "twofactor_enroll", data)
+		return
+	}
+
+	recoveryCodes, err := generateRecoveryCodes(10)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	err = a.users.EnableTwoFactor(userID, secret, step, recoveryCodes)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.sessionManager.Remove(r.Context(), "twoFactorEnrollmentSecret")
+
+	// the recovery codes are rendered directly since they can never be shown again
+	data := a.NewTemplateData(r)
+	data.RecoveryCodes = recoveryCodes
+	a.render(w, r, http.StatusOK, "twofactor_recovery", data)
+}
+
+func (a *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	var form TwoFactorDisableForm
+	err := a.decodePostForm(r, &form)
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err = a.users.VerifyPassword(userID, form.Password)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			a.sessionManager.Put(r.Context(), "flash", "Password is incorrect, two-factor authentication is still enabled.")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = a.users.DisableTwoFactor(userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been disabled.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (a *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	sessions, err := a.userSessions.AllForUser(userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	data := a.NewTemplateData(r)
+	data.Sessions = sessions
+	data.CurrentSessionID = a.sessionManager.GetString(r.Context(), "sessionID")
+	a.render(w, r, http.StatusOK, "sessions", data)
+}
+
+func (a *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	// users can only revoke their own sessions, anything else looks like a missing record
+	err = a.userSessions.Delete(r.PostForm.Get("id"), userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			a.notFound(w, r)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "flash", "The session has been logged out.")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+func (a *application) accountSessionRevokeAllPost(w http.ResponseWriter, r *http.Request) {
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err := a.userSessions.DeleteAllForUser(userID, "")
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	err = a.tokens.DeleteAllForUser(models.ScopeAuthentication, userID)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	err = a.logoutSession(r)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	a.sessionManager.Put(r.Context(), "flash", "You've been logged out everywhere.")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
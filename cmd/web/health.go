@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout - how long /readyz waits for each check before reporting it as failing
+const readinessTimeout = 2 * time.Second
+
+// healthz - the process is up and serving requests, it does not look at any dependencies
+// so a database outage does not get the instance restarted
+func (a *application) healthz(w http.ResponseWriter, r *http.Request) {
+	a.writeJSON(w, http.StatusOK, envelope{"status": "ok"})
+}
+
+// readyz - whether the instance should receive traffic, fails while shutting down or if a dependency is not reachable.
+// The response lists every check, the errors are only logged since the endpoint is public.
+func (a *application) readyz(w http.ResponseWriter, r *http.Request) {
+	if a.shuttingDown.Load() {
+		a.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "shutting down"})
+		return
+	}
+
+	checks := map[string]func(ctx context.Context) error{
+		"database": func(ctx context.Context) error {
+			return a.db.PingContext(ctx)
+		},
+		"templates": func(ctx context.Context) error {
+			if len(a.templateCache) == 0 {
+				return errors.New("template cache is empty")
+			}
+			return nil
+		},
+		"sessions": func(ctx context.Context) error {
+			// looking up a token that can't exist makes sure the session table can be queried
+			_, _, err := a.sessionManager.Store.Find("readyz")
+			return err
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		go func(name string, check func(context.Context) error) {
+			results <- result{name, check(ctx)}
+		}(name, check)
+	}
+
+	status := http.StatusOK
+	detail := map[string]string{}
+	for range checks {
+		var res result
+		select {
+		case res = <-results:
+		case <-ctx.Done():
+			// checks without a context, like the session store, may not return in time
+			for name := range checks {
+				if _, ok := detail[name]; !ok {
+					detail[name] = "failing"
+					status = http.StatusServiceUnavailable
+					a.logger.WarnContext(r.Context(), "readiness check timed out", "check", name)
+				}
+			}
+			a.writeJSON(w, status, envelope{"status": "unavailable", "checks": detail})
+			return
+		}
+
+		detail[res.name] = "ok"
+		if res.err != nil {
+			detail[res.name] = "failing"
+			status = http.StatusServiceUnavailable
+			a.logger.WarnContext(r.Context(), "readiness check failed", "check", res.name, "error", res.err)
+		}
+	}
+
+	if status != http.StatusOK {
+		a.writeJSON(w, status, envelope{"status": "unavailable", "checks": detail})
+		return
+	}
+	a.writeJSON(w, status, envelope{"status": "ok", "checks": detail})
+}
@@ -2,53 +2,191 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base32"
 	"errors"
 	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/mailer"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/totp"
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func (a *application) serverError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	a.errLog.Output(2, trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+// serverError - logs err and responds with 500, the request ID in the response lets users refer to the log record
+func (a *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	a.logError(r, err, 1)
+
+	var stack string
+	if a.dev {
+		// the trace starts at the handler that called serverError
+		stack = stackTrace(1)
+	}
+	a.errorResponse(w, r, http.StatusInternalServerError, err, stack)
 }
 
-func (a *application) clientError(w http.ResponseWriter, status int) {
-	http.Error(w, http.StatusText(status), status)
+func (a *application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+	a.errorResponse(w, r, status, nil, "")
 }
 
-func (a *application) notFound(w http.ResponseWriter) {
-	a.clientError(w, http.StatusNotFound)
+func (a *application) notFound(w http.ResponseWriter, r *http.Request) {
+	a.clientError(w, r, http.StatusNotFound)
 }
 
-func (a *application) render(w http.ResponseWriter, status int, page string, date *templateData) {
-	ts, ok := a.templateCache[fmt.Sprintf("%s.go.html", page)]
-	if !ok {
-		err := fmt.Errorf("the template %s does not exist", page)
-		a.serverError(w, err)
+// errorMessages - explanations shown on the error page, other statuses only show the status text
+var errorMessages = map[int]string{
+	http.StatusBadRequest:          "Your browser sent a request we could not understand. Please go back and try again.",
+	http.StatusForbidden:           "You don't have permission to view this page.",
+	http.StatusNotFound:            "The page you are looking for does not exist, or the snippet has expired.",
+	http.StatusTooManyRequests:     "You have sent too many requests. Please wait a moment before trying again.",
+	http.StatusInternalServerError: "Something went wrong on our side. If the problem persists, please contact us and mention the request ID.",
+}
+
+// errorResponse - renders the error page, or a JSON error for clients that prefer JSON. In -dev mode the page
+// also shows cause and the stack trace captured where the error was reported. Rendering does not go through render
+// since that reports its own failures with serverError, if the error page can't be rendered a plain text response
+// is sent instead.
+func (a *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, cause error, stack string) {
+	page := &errorPage{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Message:   errorMessages[status],
+		RequestID: getRequestID(r),
+	}
+	if a.dev && cause != nil {
+		page.Detail = cause.Error()
+		page.Stack = stack
+	}
+
+	if wantsJSON(r) {
+		data := envelope{"error": page.Title}
+		if status >= http.StatusInternalServerError {
+			data["request_id"] = page.RequestID
+		}
+		if page.Detail != "" {
+			data["detail"] = page.Detail
+		}
+		a.writeJSON(w, status, data)
 		return
 	}
 
+	// the session may not be loaded, e.g. for unknown routes, so the flash and the CSRF token can't be relied on
+	data := &templateData{
+		CurrentYear:       time.Now().Year(),
+		IsAuthenticated:   a.IsAuthenticated(r),
+		AuthenticatedUser: a.authenticatedUser(r),
+		CSRFToken:         nosurf.Token(r),
+		CSPNonce:          cspNonce(r),
+		Error:             page,
+	}
+
 	buf := new(bytes.Buffer)
-	err := ts.ExecuteTemplate(buf, "base", date)
+	err := a.executeTemplate(buf, "error", data)
 	if err != nil {
-		a.serverError(w, err)
+		a.logger.ErrorContext(r.Context(), "rendering error page failed", "error", err)
+		if a.dev {
+			page.Detail = strings.TrimSpace(fmt.Sprintf("%s\n\nRendering the error page failed: %s", page.Detail, err))
+		}
+		plainError(w, page)
 		return
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(status)
 	buf.WriteTo(w)
 }
 
+// plainError - the fallback when the error page can't be rendered
+func plainError(w http.ResponseWriter, page *errorPage) {
+	message := page.Title
+	if page.Status >= http.StatusInternalServerError {
+		message = fmt.Sprintf("%s\nRequest ID: %s", message, page.RequestID)
+	}
+	if page.Detail != "" {
+		message = strings.TrimSpace(fmt.Sprintf("%s\n\n%s\n\n%s", message, page.Detail, page.Stack))
+	}
+	http.Error(w, message, page.Status)
+}
+
+// wantsJSON - decides by the Accept header whether the client prefers JSON or HTML,
+// routes under /api/ answer with JSON when the client has no preference
+func wantsJSON(r *http.Request) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+
+	if jsonQ != htmlQ {
+		return jsonQ > htmlQ
+	}
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+func (a *application) render(w http.ResponseWriter, r *http.Request, status int, page string, date *templateData) {
+	buf := new(bytes.Buffer)
+	err := a.executeTemplate(buf, page, date)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// executeTemplate - writes the page with its layout to buf, in -dev mode the templates are re-parsed first
+// if a file changed
+func (a *application) executeTemplate(buf *bytes.Buffer, page string, data *templateData) error {
+	cache := a.templateCache
+	if a.devTemplates != nil {
+		var err error
+		cache, err = a.devTemplates.get()
+		if err != nil {
+			return err
+		}
+	}
+
+	ts, ok := cache[fmt.Sprintf("%s.go.html", page)]
+	if !ok {
+		return fmt.Errorf("the template %s does not exist", page)
+	}
+
+	return ts.ExecuteTemplate(buf, "base", data)
+}
+
 func (a *application) NewTemplateData(r *http.Request) *templateData {
 	return &templateData{
-		CurrentYear:     time.Now().Year(),
-		Flash:           a.sessionManager.PopString(r.Context(), "flash"),
-		IsAuthenticated: a.IsAuthenticated(r),
-		CSRFToken:       nosurf.Token(r),
+		CurrentYear:       time.Now().Year(),
+		Flash:             a.sessionManager.PopString(r.Context(), "flash"),
+		IsAuthenticated:   a.IsAuthenticated(r),
+		AuthenticatedUser: a.authenticatedUser(r),
+		OIDCProviders:     a.oidcProviders,
+		CSRFToken:         nosurf.Token(r),
+		CSPNonce:          cspNonce(r),
 	}
 }
 
@@ -71,9 +209,258 @@ func (a *application) decodePostForm(r *http.Request, dst any) error {
 }
 
 func (a *application) IsAuthenticated(r *http.Request) bool {
-	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
+	return a.authenticatedUser(r) != nil
+}
+
+// authenticatedUser - returns the user loaded by the authenticate middleware or nil for anonymous requests
+func (a *application) authenticatedUser(r *http.Request) *models.User {
+	user, ok := r.Context().Value(authenticatedUserContextKey).(*models.User)
 	if !ok {
+		return nil
+	}
+	return user
+}
+
+// canDeleteSnippet - owners can delete their own snippets, moderators and admins can delete any snippet
+func canDeleteSnippet(user *models.User, snippet *models.Snippet) bool {
+	if user == nil {
 		return false
 	}
-	return isAuthenticated
+	return (snippet.UserID != 0 && snippet.UserID == user.ID) || user.HasRole(models.RoleModerator)
+}
+
+// background - runs fn in a new goroutine, panics are logged instead of crashing the server
+// and a graceful shutdown waits for fn to return
+func (a *application) background(fn func()) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				a.logger.Error("panic in background task", "error", fmt.Sprint(err), "stack", string(debug.Stack()))
+			}
+		}()
+		fn()
+	}()
+}
+
+// sendMail - delivers the message in the background so slow mail servers don't block the response
+func (a *application) sendMail(msg mailer.Message) {
+	a.background(func() {
+		err := a.mailer.Send(msg)
+		if err != nil {
+			a.logger.Error("sending email failed", "to", msg.To, "error", err)
+		}
+	})
+}
+
+// sendVerificationEmail - issues a new verification token and mails the link to the user
+func (a *application) sendVerificationEmail(user *models.User) error {
+	token, err := a.tokens.New(user.ID, emailVerificationTTL, models.ScopeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	a.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\nThanks for signing up! Please confirm your email address by opening the link below:\n\n%s/user/verify/%s\n\n"+
+			"Until then you won't be able to publish snippets.\n",
+			user.Name, a.baseURL, token.Plaintext),
+	})
+
+	return nil
+}
+
+// loginWithSecondFactor - logs the user in right away unless two-factor authentication is enabled,
+// then the login is only completed once the code was entered on /user/login/2fa
+func (a *application) loginWithSecondFactor(w http.ResponseWriter, r *http.Request, id int, rememberMe bool) {
+	secret, err := a.users.TOTPSecret(id)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if secret != "" {
+		// the first factor was right, but the user is only logged in once the second factor is confirmed too
+		err = a.sessionManager.RenewToken(r.Context())
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+		a.sessionManager.Put(r.Context(), "pendingTwoFactorUserID", id)
+		a.sessionManager.Put(r.Context(), "pendingTwoFactorDeadline", time.Now().Add(twoFactorLoginTTL))
+		a.sessionManager.Put(r.Context(), "pendingTwoFactorRememberMe", rememberMe)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	a.completeLogin(w, r, id, rememberMe)
+}
+
+// completeLogin - starts an authenticated session for the user and sends them on,
+// remembered sessions survive closing the browser and are not subject to the idle timeout
+func (a *application) completeLogin(w http.ResponseWriter, r *http.Request, id int, rememberMe bool) {
+	// regenerate user session
+	err := a.sessionManager.RenewToken(r.Context()) // will change the id of the current user session retain the data
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// record the session so the user can see and revoke it from their account
+	sessionID, err := a.userSessions.Insert(id, clientIP(r), r.UserAgent())
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+
+	// set id in user session
+	a.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	a.sessionManager.Put(r.Context(), "sessionID", sessionID)
+	a.sessionManager.Put(r.Context(), "loginAt", time.Now())
+	a.sessionManager.Put(r.Context(), "lastActivityAt", time.Now())
+	a.sessionManager.RememberMe(r.Context(), rememberMe)
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// pendingTwoFactorUserID - returns the user who passed the password check but still has to enter a code,
+// or 0 if there is none or the attempt took too long
+func (a *application) pendingTwoFactorUserID(r *http.Request) int {
+	deadline := a.sessionManager.GetTime(r.Context(), "pendingTwoFactorDeadline")
+	if time.Now().After(deadline) {
+		return 0
+	}
+	return a.sessionManager.GetInt(r.Context(), "pendingTwoFactorUserID")
+}
+
+// checkSecondFactor - accepts either a current TOTP code that was not used before or one of the unused recovery codes
+func (a *application) checkSecondFactor(userID int, code string) (bool, error) {
+	secret, err := a.users.TOTPSecret(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if secret == "" {
+		return true, nil
+	}
+	if step, ok := totp.Match(secret, code, time.Now()); ok {
+		return a.users.UseTOTPStep(userID, step)
+	}
+
+	return a.users.UseRecoveryCode(userID, code)
+}
+
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes, nil
+}
+
+// clientIP - the address of the connecting client, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// retryAfter - formats a duration as whole seconds for the Retry-After header
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// loginAttempt - counts an attempt against the account and the client's IP address before the credentials are
+// checked and returns how long logins are blocked. The attempt counts as failed until loginSucceeded takes it back.
+func (a *application) loginAttempt(r *http.Request, accountKey string) (time.Duration, error) {
+	accountLockedFor, err := a.accountLockout.Attempt(accountKey)
+	if err != nil || accountLockedFor > 0 {
+		return accountLockedFor, err
+	}
+
+	ipLockedFor, err := a.ipLockout.Attempt("ip:" + clientIP(r))
+	if err != nil {
+		return 0, err
+	}
+	if ipLockedFor > 0 {
+		// the attempt is not made, so it must not count against the account
+		return ipLockedFor, a.accountLockout.Forgive(accountKey)
+	}
+
+	return 0, nil
+}
+
+// loginFailed - logs the lockouts caused by a failed attempt, loginAttempt already counted it
+func (a *application) loginFailed(r *http.Request, accountKey string) error {
+	ipKey := "ip:" + clientIP(r)
+
+	accountLockedFor, err := a.accountLockout.Check(accountKey)
+	if err != nil {
+		return err
+	}
+	if accountLockedFor > 0 {
+		a.logger.InfoContext(r.Context(), "login locked", "key", accountKey, "locked_for", accountLockedFor.Round(time.Second).String(), "ip", clientIP(r))
+	}
+
+	ipLockedFor, err := a.ipLockout.Check(ipKey)
+	if err != nil {
+		return err
+	}
+	if ipLockedFor > 0 {
+		a.logger.InfoContext(r.Context(), "login locked", "key", ipKey, "locked_for", ipLockedFor.Round(time.Second).String())
+	}
+
+	return nil
+}
+
+// loginSucceeded - forgets the failures of the account and takes back the attempt counted against the IP address,
+// failures with other accounts from the same address are kept
+func (a *application) loginSucceeded(r *http.Request, accountKey string) error {
+	err := a.accountLockout.Succeed(accountKey)
+	if err != nil {
+		return err
+	}
+
+	return a.ipLockout.Forgive("ip:" + clientIP(r))
+}
+
+// rateLimitKey - identifies the client for rate limiting, the session is only read when the
+// authenticate middleware already ran so this is safe outside the dynamic chain
+func (a *application) rateLimitKey(r *http.Request) string {
+	if id := a.apiUserID(r); id != 0 {
+		return fmt.Sprintf("user:%d", id)
+	}
+	if a.IsAuthenticated(r) {
+		return fmt.Sprintf("user:%d", a.sessionManager.GetInt(r.Context(), "authenticatedUserID"))
+	}
+	return "ip:" + clientIP(r)
+}
+
+// logoutSession - ends the authenticated part of the current session and forgets its record
+func (a *application) logoutSession(r *http.Request) error {
+	userID := a.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err := a.userSessions.Delete(a.sessionManager.GetString(r.Context(), "sessionID"), userID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	err = a.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{"authenticatedUserID", "sessionID", "loginAt", "lastActivityAt"} {
+		a.sessionManager.Remove(r.Context(), key)
+	}
+	a.sessionManager.RememberMe(r.Context(), false)
+
+	return nil
 }
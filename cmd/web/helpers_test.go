@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingHandler stands in for a handler reporting an error
+func failingHandler(a *application) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a.serverError(w, r, errors.New("boom"))
+	}
+}
+
+// plainStack returns the stack trace from the plain text error page, which is sent without templates.
+// It is the last paragraph after the detail and the rendering failure.
+func plainStack(t *testing.T, body string) string {
+	t.Helper()
+
+	i := strings.LastIndex(body, "\n\n")
+	if !strings.Contains(body, "boom") || i == -1 {
+		t.Fatalf("got body %q; want the error detail and a stack trace", body)
+	}
+	return body[i+2:]
+}
+
+func TestServerErrorStack(t *testing.T) {
+	a := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		dev:    true,
+	}
+
+	rr := httptest.NewRecorder()
+	failingHandler(a).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+	}
+
+	stack := plainStack(t, rr.Body.String())
+	if first, _, _ := strings.Cut(stack, "\n"); !strings.HasPrefix(first, "github.com/danyelkeddah/snippetbox/cmd/web.failingHandler.func1(") {
+		t.Errorf("got stack starting with %q; want the handler", first)
+	}
+	if strings.Contains(stack, "serverError") || strings.Contains(stack, "errorResponse") {
+		t.Errorf("got helper frames in the stack:\n%s", stack)
+	}
+}
+
+func TestRecoverPanicStack(t *testing.T) {
+	a := &application{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		dev:    true,
+	}
+
+	handler := a.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	stack := plainStack(t, rr.Body.String())
+	if first, _, _ := strings.Cut(stack, "\n"); !strings.HasPrefix(first, "runtime.gopanic(") {
+		t.Errorf("got stack starting with %q; want the panic", first)
+	}
+	if strings.Contains(stack, "recoverPanic.func1.1(") {
+		t.Errorf("got the deferred recover in the stack:\n%s", stack)
+	}
+	if !strings.Contains(stack, "TestRecoverPanicStack.func1(") {
+		t.Errorf("got stack without the panicking handler:\n%s", stack)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// newLogger - returns a logger writing text or JSON records at level and above
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: l}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+// contextHandler - adds the request ID to every record that is logged with a context of a request,
+// e.g. with logger.InfoContext(r.Context(), ...)
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// requestIDRX - request IDs passed in by a proxy are only trusted if they can't mess up the logs
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID - keeps the X-Request-ID set by a proxy in front of us or generates a new one,
+// stores it in the request context and echoes it in the response
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				panic(err)
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// secretPathPrefixes - paths ending in a token that grants access to an account, e.g. /user/password/reset/:token
+var secretPathPrefixes = []string{"/user/password/reset/", "/user/verify/"}
+
+// redactURL - replaces the token in the URLs of secretPathPrefixes so live tokens don't end up in the logs,
+// rawURL can be a request URI or an absolute URL like the Referer
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	for _, prefix := range secretPathPrefixes {
+		if strings.HasPrefix(u.Path, prefix) && len(u.Path) > len(prefix) {
+			u.Path = prefix + "REDACTED"
+			u.RawPath = ""
+			return u.String()
+		}
+	}
+	return rawURL
+}
+
+// stackTrace - formats the calling goroutine's stack like a panic does, skip is the number of frames
+// between the first one to include and stackTrace
+func stackTrace(skip int) string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s()\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+// logError - logs err together with the request and the file and line that called the error helper,
+// skip is the number of stack frames between the caller and logError
+func (a *application) logError(r *http.Request, err error, skip int) {
+	var pcs [1]uintptr
+	runtime.Callers(skip+2, pcs[:])
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+
+	a.logger.LogAttrs(r.Context(), slog.LevelError, err.Error(),
+		slog.String("method", r.Method),
+		slog.String("uri", redactURL(r.URL.RequestURI())),
+		slog.String("source", fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)),
+	)
+}
@@ -4,58 +4,250 @@ import (
 	"crypto/tls"
 	"database/sql"
 	"flag"
+	"fmt"
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
+	"github.com/danyelkeddah/snippetbox/internal/compress"
+	"github.com/danyelkeddah/snippetbox/internal/lockout"
+	"github.com/danyelkeddah/snippetbox/internal/mailer"
 	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/ratelimit"
+	"github.com/danyelkeddah/snippetbox/internal/rotate"
+	"github.com/danyelkeddah/snippetbox/ui"
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql"
 	"html/template"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type application struct {
-	errLog         *log.Logger
-	infoLog        *log.Logger
+	logger         *slog.Logger
+	accessLog      *accessLogger
 	snippets       *models.SnippetModel
 	users          *models.UserModel
+	tokens         *models.TokenModel
+	userSessions   *models.SessionModel
+	reports        *models.ReportModel
+	identities     *models.IdentityModel
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	mailer         mailer.Mailer
+	baseURL        string
+	accountLockout *lockout.Limiter
+	ipLockout      *lockout.Limiter
+	limiters       limiters
+	// sessionLifetime and idleTimeout apply to sessions that were not remembered on login
+	sessionLifetime time.Duration
+	idleTimeout     time.Duration
+	oidcProviders   []*oidcProvider
+	metrics         *appMetrics
+	db              *sql.DB
+	// shuttingDown makes /readyz fail once a shutdown signal was received
+	shuttingDown atomic.Bool
+	// wg tracks the goroutines started with background so shutdown can wait for them
+	wg sync.WaitGroup
+	// uiFiles holds the static files and templates, ui.Files unless they are read from disk in -dev mode
+	uiFiles fs.FS
+	// assets has the fingerprinted names of the static files, nil in -dev mode
+	assets *assetTable
+	// dev shows error details in the browser, devTemplates re-parses changed templates
+	dev          bool
+	devTemplates *devTemplates
+	// compress is the response compression middleware, nil if compression is disabled
+	compress func(http.Handler) http.Handler
+	security securityHeaders
+}
+
+// limiters - token buckets per client for each route group, all nil when rate limiting is disabled
+type limiters struct {
+	global *ratelimit.Limiter
+	auth   *ratelimit.Limiter
+	create *ratelimit.Limiter
 }
 
 func main() {
 	addr := flag.String("addr", ":4000", "HTTP network address")
 	dsn := flag.String("dsn", "root:@/snippetbox?parseTime=true", "MySQL data source name")
+	baseURL := flag.String("base-url", "https://localhost:4000", "Public URL of the site, used for links in emails")
+	smtpHost := flag.String("smtp-host", "", "SMTP host, emails are only logged when empty")
+	smtpPort := flag.Int("smtp-port", 25, "SMTP port")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	smtpSender := flag.String("smtp-sender", "Snippetbox <no-reply@snippetbox.local>", "SMTP sender")
+	mailDir := flag.String("mail-dir", "", "Write emails as files into this directory instead of sending them")
+	lockoutStore := flag.String("lockout-store", "memory", "Where failed login attempts are tracked: memory or db")
+	sessionLifetime := flag.Duration("session-lifetime", 12*time.Hour, "Maximum age of a session when \"remember me\" was not checked")
+	rememberLifetime := flag.Duration("session-remember-lifetime", 30*24*time.Hour, "Maximum age of a remembered session")
+	idleTimeout := flag.Duration("session-idle-timeout", 30*time.Minute, "Log out sessions that were not remembered after this much inactivity, 0 disables it")
+	limiterEnabled := flag.Bool("limiter-enabled", true, "Enable rate limiting")
+	globalRate := ratelimit.Rate{Limit: 20, Per: time.Second}
+	flag.Func("limiter-global", "Requests allowed per client across the whole site except static files and health probes (default 20/s)", rateFlag(&globalRate))
+	authRate := ratelimit.Rate{Limit: 10, Per: time.Minute}
+	flag.Func("limiter-auth", "Login, signup and password reset submissions allowed per client (default 10/m)", rateFlag(&authRate))
+	createRate := ratelimit.Rate{Limit: 30, Per: time.Hour}
+	flag.Func("limiter-create", "Snippets a client may create (default 30/h)", rateFlag(&createRate))
+	oidcProviders := flag.String("oidc-providers", "", "JSON file listing the OpenID Connect providers users can log in with")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	logLevel := flag.String("log-level", "info", "Minimum level of logged records: debug, info, warn or error")
+	accessLogFormat := flag.String("access-log-format", "log", "Access log format: log (part of the application log), common, combined or json")
+	accessLogFile := flag.String("access-log", "", "Write the access log to this file instead of stdout, it is rotated by size")
+	accessLogMaxSize := flag.Int64("access-log-max-size", 100, "Rotate the access log file once it is larger than this many MB")
+	accessLogMaxBackups := flag.Int("access-log-max-backups", 5, "Number of rotated access log files to keep")
+	metricsAddr := flag.String("metrics-addr", "localhost:9090", "Serve /metrics over plain HTTP on this address, keep it private, empty disables the endpoint")
+	shutdownDelay := flag.Duration("shutdown-delay", 5*time.Second, "Time between failing /readyz and closing the listener on shutdown")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "Maximum time to wait for running requests and background tasks on shutdown")
+	dev := flag.Bool("dev", false, "Development mode: read templates and static files from -ui-dir, show error details in the browser and disable caching of static files")
+	uiDir := flag.String("ui-dir", "./ui", "Directory with the html and static folders, only used in development mode")
+	compressEnabled := flag.Bool("compress", true, "Compress responses with gzip or deflate when the client accepts it")
+	compressMinSize := flag.Int("compress-min-size", 1024, "Responses smaller than this many bytes are sent uncompressed")
+	snippetCacheSize := flag.Int("snippet-cache-size", 0, "Number of snippets kept in memory in front of the database, 0 disables the cache")
+	snippetCacheTTL := flag.Duration("snippet-cache-ttl", time.Minute, "How long a cached snippet is used before it is read again")
+	csp := flag.String("csp", defaultCSP, "Content-Security-Policy header, {nonce} is replaced with a new nonce for every request")
+	permissionsPolicy := flag.String("permissions-policy", defaultPermissionsPolicy, "Permissions-Policy header")
+	hstsMaxAge := flag.Duration("hsts-max-age", 365*24*time.Hour, "max-age of the Strict-Transport-Security header, 0 leaves the header out")
 	flag.Parse()
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	logger, err := newLogger(os.Stdout, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if !slices.Contains(accessLogFormats, *accessLogFormat) {
+		fatal(logger, fmt.Errorf("unknown access log format %q", *accessLogFormat))
+	}
+	accessLog := &accessLogger{format: *accessLogFormat, records: logger, out: os.Stdout, logger: logger}
+	if *accessLogFile != "" {
+		f := &rotate.File{Path: *accessLogFile, MaxSize: *accessLogMaxSize << 20, MaxBackups: *accessLogMaxBackups}
+		defer f.Close()
+		accessLog.out = f
+		// the application log stays on stdout, access log records get their own logger writing to the file
+		accessLog.records = slog.New(contextHandler{slog.NewJSONHandler(f, nil)})
+	}
+
+	var m mailer.Mailer
+	switch {
+	case *mailDir != "":
+		m = &mailer.Dir{Path: *mailDir, Sender: *smtpSender}
+	case *smtpHost != "":
+		m = &mailer.SMTP{Host: *smtpHost, Port: *smtpPort, Username: *smtpUsername, Password: *smtpPassword, Sender: *smtpSender}
+	default:
+		m = &mailer.Log{Logger: slog.NewLogLogger(logger.Handler(), slog.LevelInfo)}
+	}
+
 	db, err := openDB(*dsn)
 	if err != nil {
-		errorLog.Fatal(errorLog)
+		fatal(logger, err)
 	}
 	defer db.Close()
-	templateCache, err := NewTemplateCache()
+
+	var uiFiles fs.FS = ui.Files
+	var devTmpl *devTemplates
+	if *dev {
+		logger.Warn("development mode, error details are shown to visitors", "ui_dir", *uiDir)
+		uiFiles = os.DirFS(*uiDir)
+		devTmpl = &devTemplates{fsys: uiFiles}
+	}
+	var assets *assetTable
+	if !*dev {
+		assets, err = newAssetTable(uiFiles)
+		if err != nil {
+			fatal(logger, err)
+		}
+	}
+	templateCache, err := NewTemplateCache(uiFiles, assets)
 	if err != nil {
-		errorLog.Fatal(err)
+		fatal(logger, err)
 	}
 
 	formDecoder := form.NewDecoder()
 
+	var attempts lockout.Store
+	switch *lockoutStore {
+	case "memory":
+		attempts = lockout.NewMemoryStore(24 * time.Hour)
+	case "db":
+		attempts = &models.LoginAttemptModel{DB: db}
+	default:
+		fatal(logger, fmt.Errorf("unknown lockout store %q", *lockoutStore))
+	}
+
 	sessionManager := scs.New() // return a pointer to sessionManager struct
 	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
+	// the store keeps sessions as long as a remembered one may live, sessionTimeouts expires the others earlier
+	sessionManager.Lifetime = *rememberLifetime
+	sessionManager.Cookie.Persist = false
+
+	var snippetCache *models.SnippetCache
+	if *snippetCacheSize > 0 {
+		snippetCache = models.NewSnippetCache(*snippetCacheSize, *snippetCacheTTL)
+	}
 
 	app := &application{
-		errLog:         errorLog,
-		infoLog:        infoLog,
-		snippets:       &models.SnippetModel{DB: db},
+		logger:         logger,
+		accessLog:      accessLog,
+		snippets:       &models.SnippetModel{DB: db, Cache: snippetCache},
 		users:          &models.UserModel{DB: db},
+		tokens:         &models.TokenModel{DB: db},
+		userSessions:   &models.SessionModel{DB: db},
+		reports:        &models.ReportModel{DB: db},
+		identities:     &models.IdentityModel{DB: db},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		mailer:         m,
+		baseURL:        strings.TrimRight(*baseURL, "/"),
+		// an account is locked after 5 failures, starting at 1 minute and doubling up to an hour
+		accountLockout: &lockout.Limiter{Store: attempts, Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 24 * time.Hour},
+		// an IP address gets more room since many users can share one
+		ipLockout:       &lockout.Limiter{Store: attempts, Threshold: 20, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 24 * time.Hour},
+		sessionLifetime: *sessionLifetime,
+		idleTimeout:     *idleTimeout,
+		metrics:         newAppMetrics(db, &models.SessionModel{DB: db}),
+		db:              db,
+		uiFiles:         uiFiles,
+		assets:          assets,
+		dev:             *dev,
+		devTemplates:    devTmpl,
+		security: securityHeaders{
+			csp:               *csp,
+			permissionsPolicy: *permissionsPolicy,
+			hstsMaxAge:        *hstsMaxAge,
+		},
+	}
+
+	if *limiterEnabled {
+		app.limiters = limiters{
+			global: ratelimit.New(globalRate),
+			auth:   ratelimit.New(authRate),
+			create: ratelimit.New(createRate),
+		}
+		for _, l := range []*ratelimit.Limiter{app.limiters.global, app.limiters.auth, app.limiters.create} {
+			defer l.StartCleanup(time.Minute)()
+		}
+	}
+
+	if *compressEnabled {
+		app.compress = compress.Middleware(compress.Config{
+			Encoders: []compress.Encoder{compress.Gzip, compress.Deflate},
+			MinSize:  *compressMinSize,
+		})
+	}
+
+	if *oidcProviders != "" {
+		app.oidcProviders, err = loadOIDCProviders(*oidcProviders, app.baseURL)
+		if err != nil {
+			fatal(logger, err)
+		}
 	}
 
 	tlsConfig := &tls.Config{
@@ -64,7 +256,7 @@ func main() {
 
 	srv := &http.Server{
 		Addr:      *addr,
-		ErrorLog:  errorLog,
+		ErrorLog:  slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		Handler:   app.routes(),
 		TLSConfig: tlsConfig,
 		/**
@@ -81,9 +273,40 @@ func main() {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
-	infoLog.Printf("Starting server on %s", srv.Addr)
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
+	var metricsSrv *http.Server
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", app.metrics.registry)
+		metricsSrv = &http.Server{
+			Addr:         *metricsAddr,
+			ErrorLog:     srv.ErrorLog,
+			Handler:      mux,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+	}
+
+	err = app.serve(srv, metricsSrv, *shutdownDelay, *shutdownTimeout)
+	if err != nil {
+		fatal(logger, err)
+	}
+}
+
+// fatal - logs err and exits, deferred functions are not run
+func fatal(logger *slog.Logger, err error) {
+	logger.Error(err.Error())
+	os.Exit(1)
+}
+
+func rateFlag(rate *ratelimit.Rate) func(string) error {
+	return func(s string) error {
+		r, err := ratelimit.ParseRate(s)
+		if err != nil {
+			return err
+		}
+		*rate = r
+		return nil
+	}
 }
 
 func openDB(dsn string) (*sql.DB, error) {
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/danyelkeddah/snippetbox/internal/metrics"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// appMetrics - the metrics served on /metrics, requests are labelled with the route pattern rather than the path
+// so that snippet IDs don't create a new series each
+type appMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec
+	duration        *metrics.HistogramVec
+	snippetsCreated *metrics.CounterVec
+	snippetViews    *metrics.CounterVec
+}
+
+// activeSessionWindow - sessions used within this window count as active
+const activeSessionWindow = 5 * time.Minute
+
+func newAppMetrics(db *sql.DB, sessions *models.SessionModel) *appMetrics {
+	reg := metrics.NewRegistry()
+	m := &appMetrics{
+		registry:        reg,
+		requests:        reg.NewCounterVec("snippetbox_http_requests_total", "HTTP requests handled, by route pattern, method and status.", "route", "method", "status"),
+		duration:        reg.NewHistogramVec("snippetbox_http_request_duration_seconds", "Time taken to handle HTTP requests.", nil, "route", "method", "status"),
+		snippetsCreated: reg.NewCounterVec("snippetbox_snippets_created_total", "Snippets created, by source (web or api).", "source"),
+		snippetViews:    reg.NewCounterVec("snippetbox_snippet_views_total", "Snippets viewed, by source (web, raw or api).", "source"),
+	}
+
+	// sessions are counted in the database so the gauges are right with several instances behind a load balancer
+	reg.NewGaugeFunc("snippetbox_sessions", "Sessions of logged-in users.", func() (float64, error) {
+		total, _, err := sessions.Count(activeSessionWindow)
+		return float64(total), err
+	})
+	reg.NewGaugeFunc("snippetbox_sessions_active", "Sessions of logged-in users used within the last 5 minutes.", func() (float64, error) {
+		_, active, err := sessions.Count(activeSessionWindow)
+		return float64(active), err
+	})
+
+	dbStat := func(fn func(sql.DBStats) float64) func() (float64, error) {
+		return func() (float64, error) {
+			return fn(db.Stats()), nil
+		}
+	}
+	reg.NewGaugeFunc("snippetbox_db_max_open_connections", "Maximum number of open connections to the database.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	reg.NewGaugeFunc("snippetbox_db_open_connections", "Established connections to the database, in use and idle.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	reg.NewGaugeFunc("snippetbox_db_in_use_connections", "Connections to the database that are in use.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	reg.NewGaugeFunc("snippetbox_db_idle_connections", "Idle connections to the database.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	reg.NewCounterFunc("snippetbox_db_wait_count_total", "Times a query had to wait for a free connection.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	reg.NewCounterFunc("snippetbox_db_wait_duration_seconds_total", "Time spent waiting for a free connection.",
+		dbStat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	reg.NewCounterFunc("snippetbox_db_max_idle_closed_total", "Connections closed because there were too many idle ones.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	reg.NewCounterFunc("snippetbox_db_max_lifetime_closed_total", "Connections closed because they reached their maximum lifetime.",
+		dbStat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+
+	reg.NewGaugeFunc("go_goroutines", "Number of goroutines.", func() (float64, error) {
+		return float64(runtime.NumGoroutine()), nil
+	})
+
+	return m
+}
+
+// routePattern - filled in by withRoute once the router matched a route, requests that match no route keep "unmatched"
+type routePattern struct {
+	pattern string
+}
+
+// withRoute - tells collectMetrics which route pattern handled the request
+func withRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := r.Context().Value(routePatternContextKey).(*routePattern); ok {
+			p.pattern = pattern
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var knownMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodOptions}
+
+// collectMetrics - counts requests and records how long they took, it must come before the rate limiter
+// in the chain so that rejected requests are counted as well
+func (a *application) collectMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := &routePattern{pattern: "unmatched"}
+		rr := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rr, r.WithContext(context.WithValue(r.Context(), routePatternContextKey, route)))
+
+		status := rr.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		method := r.Method
+		if !slices.Contains(knownMethods, method) {
+			// clients choose the method, keep them from creating any number of series
+			method = "other"
+		}
+		labels := []string{route.pattern, method, strconv.Itoa(status)}
+		a.metrics.requests.With(labels...).Inc()
+		a.metrics.duration.With(labels...).Observe(time.Since(start).Seconds())
+	})
+}
@@ -2,30 +2,83 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/ratelimit"
 	"github.com/justinas/nosurf"
 	"net/http"
+	"strings"
+	"time"
 )
 
-func secureHeaders(next http.Handler) http.Handler {
+// secureHeaders - sets the security headers of every response. The CSP gets a new nonce per request,
+// templates can allow inline scripts and styles with nonce="{{ .CSPNonce }}".
+func (a *application) secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		_, err := rand.Read(b)
+		if err != nil {
+			panic(err)
+		}
+		// URL-safe base64 has no + that html/template would escape in the nonce attribute
+		nonce := base64.RawURLEncoding.EncodeToString(b)
 
-		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+		w.Header().Set("Content-Security-Policy", strings.ReplaceAll(a.security.csp, "{nonce}", nonce))
+		w.Header().Set("Reporting-Endpoints", `csp="/csp-report"`)
+		if a.security.hstsMaxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", int(a.security.hstsMaxAge.Seconds())))
+		}
+		w.Header().Set("Permissions-Policy", a.security.permissionsPolicy)
+		w.Header().Set("Cross-Origin-Opener-Policy", "same-origin")
+		w.Header().Set("Cross-Origin-Resource-Policy", "same-origin")
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
 		w.Header().Set("X-XSS-Protection", "0")
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), cspNonceContextKey, nonce)
+		next.ServeHTTP(w, r.WithContext(ctx))
 		// MARK: Any code here will execute on the way back up the chain.
 	})
 }
 
+// rateLimit - rejects requests with 429 once the client's bucket in limiter is empty,
+// clients are identified by user when authenticated and by IP address otherwise
+func (a *application) rateLimit(limiter *ratelimit.Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, wait := limiter.Allow(a.rateLimitKey(r))
+			if !allowed {
+				w.Header().Set("Retry-After", retryAfter(wait))
+				if strings.HasPrefix(r.URL.Path, "/api/") {
+					a.apiError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				} else {
+					a.clientError(w, r, http.StatusTooManyRequests)
+				}
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// logRequest - writes the access log entry once the response is complete so it includes the status, size and duration,
+// it must wrap recoverPanic, i.e. come before it in the chain, so that panics are logged as 500 responses
 func (a *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		a.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
-		next.ServeHTTP(w, r)
+		start := time.Now()
+		rr := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rr, r)
 		// MARK: Any code here will execute on the way back up the chain.
+		a.accessLog.log(r, rr, start)
 	})
 }
 
@@ -38,8 +91,11 @@ func (a *application) recoverPanic(next http.Handler) http.Handler {
 			if err := recover(); err != nil {
 				// close the current http connection after response sent
 				w.Header().Set("Connection", "close")
-				// normalize err returned from the recover func
-				a.serverError(w, fmt.Errorf("%s", err))
+				// the trace starts at the panic, leaving out this function
+				stack := stackTrace(1)
+				a.logger.ErrorContext(r.Context(), "panic recovered", "error", fmt.Sprint(err),
+					"method", r.Method, "uri", redactURL(r.URL.RequestURI()), "stack", stack)
+				a.errorResponse(w, r, http.StatusInternalServerError, fmt.Errorf("panic: %v", err), stack)
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -63,8 +119,73 @@ func (a *application) requiredAuthentication(next http.Handler) http.Handler {
 	})
 }
 
-func noSurf(next http.Handler) http.Handler {
+// requireVerifiedEmail - only lets users who confirmed their email address through,
+// it must come after requiredAuthentication in the chain
+func (a *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authenticatedUser(r).EmailVerified() {
+			a.sessionManager.Put(r.Context(), "flash", "Please verify your email address before publishing snippets.")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireRole - responds with 403 Forbidden unless the user has role or a more privileged one,
+// it must come after requiredAuthentication in the chain
+func (a *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.authenticatedUser(r).HasRole(role) {
+				a.clientError(w, r, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sessionTimeouts - logs out sessions that were not remembered once they are older than the session
+// lifetime or have been idle for longer than the idle timeout, remembered sessions only expire with the cookie
+func (a *application) sessionTimeouts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.sessionManager.GetInt(r.Context(), "authenticatedUserID") == 0 || a.sessionManager.GetBool(r.Context(), "__rememberMe") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		loginAt := a.sessionManager.GetTime(r.Context(), "loginAt")
+		lastActivityAt := a.sessionManager.GetTime(r.Context(), "lastActivityAt")
+
+		if now.Sub(loginAt) > a.sessionLifetime || (a.idleTimeout > 0 && now.Sub(lastActivityAt) > a.idleTimeout) {
+			err := a.logoutSession(r)
+			if err != nil {
+				a.serverError(w, r, err)
+				return
+			}
+			a.sessionManager.Put(r.Context(), "flash", "Your session has expired, please log in again.")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// only write when it matters, every Put makes scs save the session again
+		if now.Sub(lastActivityAt) > time.Minute {
+			a.sessionManager.Put(r.Context(), "lastActivityAt", now)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *application) noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.clientError(w, r, http.StatusBadRequest)
+	}))
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
@@ -81,15 +202,87 @@ func (a *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		exists, err := a.users.Exists(id)
+		user, err := a.users.Get(id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			a.serverError(w, r, err)
+			return
+		}
+		// disabled users are logged out the same way as deleted ones
+		exists := user != nil && !user.Disabled
+
+		// a session whose record was revoked (or that predates session records) is logged out
+		sessionID := a.sessionManager.GetString(r.Context(), "sessionID")
+		if exists && sessionID != "" {
+			exists, err = a.userSessions.Exists(sessionID, id)
+			if err != nil {
+				a.serverError(w, r, err)
+				return
+			}
+		}
+		if !exists || sessionID == "" {
+			a.sessionManager.Remove(r.Context(), "authenticatedUserID")
+			a.sessionManager.Remove(r.Context(), "sessionID")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		err = a.userSessions.Touch(sessionID, clientIP(r))
 		if err != nil {
-			a.serverError(w, err)
+			a.serverError(w, r, err)
 			return
 		}
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
-			r = r.WithContext(ctx)
+
+		ctx := context.WithValue(r.Context(), authenticatedUserContextKey, user)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateToken - looks up the user for a bearer token sent by API clients,
+// requests without an Authorization header continue anonymously
+func (a *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, token, found := strings.Cut(authorizationHeader, " ")
+		if !found || scheme != "Bearer" || token == "" {
+			a.invalidTokenResponse(w)
+			return
+		}
+
+		id, err := a.tokens.UserID(models.ScopeAuthentication, token)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				a.invalidTokenResponse(w)
+			} else {
+				a.apiServerError(w, r, err)
+			}
+			return
 		}
+
+		ctx := context.WithValue(r.Context(), apiUserIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (a *application) requireTokenAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.apiUserID(r) == 0 {
+			a.invalidTokenResponse(w)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (a *application) invalidTokenResponse(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	a.apiError(w, http.StatusUnauthorized, "invalid or missing authentication token")
+}
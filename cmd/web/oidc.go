@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/oidc"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// oidcProvider - an identity provider users can log in with, configured in the file passed with -oidc-providers
+type oidcProvider struct {
+	Name        string
+	DisplayName string
+	// AutoProvision creates a user on the first login if no user has the verified email address from the provider
+	AutoProvision bool
+	client        *oidc.Provider
+}
+
+var providerNameRX = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// loadOIDCProviders - reads the JSON provider configuration, an array of objects like
+//
+//	{"name": "corp", "display_name": "Corp SSO", "issuer": "https://sso.example.com", "client_id": "...",
+//	 "client_secret": "...", "scopes": ["email", "profile"], "auto_provision": true}
+func loadOIDCProviders(path, baseURL string) ([]*oidcProvider, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []struct {
+		Name          string   `json:"name"`
+		DisplayName   string   `json:"display_name"`
+		Issuer        string   `json:"issuer"`
+		ClientID      string   `json:"client_id"`
+		ClientSecret  string   `json:"client_secret"`
+		Scopes        []string `json:"scopes"`
+		AutoProvision bool     `json:"auto_provision"`
+	}
+	err = json.Unmarshal(b, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var providers []*oidcProvider
+	seen := map[string]bool{}
+	for _, c := range configs {
+		if !providerNameRX.MatchString(c.Name) || seen[c.Name] {
+			return nil, fmt.Errorf("%s: provider names must be unique and only contain a-z, 0-9 and -, got %q", path, c.Name)
+		}
+		if c.Issuer == "" || c.ClientID == "" {
+			return nil, fmt.Errorf("%s: provider %q needs an issuer and a client_id", path, c.Name)
+		}
+		seen[c.Name] = true
+
+		if c.DisplayName == "" {
+			c.DisplayName = c.Name
+		}
+		if c.Scopes == nil {
+			c.Scopes = []string{"email", "profile"}
+		}
+
+		providers = append(providers, &oidcProvider{
+			Name:          c.Name,
+			DisplayName:   c.DisplayName,
+			AutoProvision: c.AutoProvision,
+			client: oidc.New(oidc.Config{
+				Issuer:       c.Issuer,
+				ClientID:     c.ClientID,
+				ClientSecret: c.ClientSecret,
+				RedirectURL:  baseURL + "/user/login/oidc/" + c.Name + "/callback",
+				Scopes:       c.Scopes,
+			}, nil),
+		})
+	}
+
+	return providers, nil
+}
+
+func (a *application) oidcProvider(r *http.Request) *oidcProvider {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("provider")
+	for _, p := range a.oidcProviders {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// userLoginOIDC - sends the user to the provider's login page, the state, nonce and PKCE verifier
+// are kept in the session to check the callback against
+func (a *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	provider := a.oidcProvider(r)
+	if provider == nil {
+		a.notFound(w, r)
+		return
+	}
+
+	values := map[string]string{}
+	for _, key := range []string{"oidcState", "oidcNonce", "oidcVerifier"} {
+		value, err := oidc.RandomString()
+		if err != nil {
+			a.serverError(w, r, err)
+			return
+		}
+		values[key] = value
+	}
+
+	authURL, err := provider.client.AuthCodeURL(r.Context(), values["oidcState"], values["oidcNonce"], values["oidcVerifier"])
+	if err != nil {
+		a.logger.WarnContext(r.Context(), "oidc provider unavailable", "provider", provider.Name, "error", err)
+		a.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s is not available right now, please try again later.", provider.DisplayName))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	a.sessionManager.Put(r.Context(), "oidcProvider", provider.Name)
+	for key, value := range values {
+		a.sessionManager.Put(r.Context(), key, value)
+	}
+
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+func (a *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := a.oidcProvider(r)
+	if provider == nil {
+		a.notFound(w, r)
+		return
+	}
+
+	// everything is popped so a callback URL can't be replayed
+	ctx := r.Context()
+	providerName := a.sessionManager.PopString(ctx, "oidcProvider")
+	state := a.sessionManager.PopString(ctx, "oidcState")
+	nonce := a.sessionManager.PopString(ctx, "oidcNonce")
+	verifier := a.sessionManager.PopString(ctx, "oidcVerifier")
+
+	query := r.URL.Query()
+	if providerName != provider.Name || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		a.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("error") != "" {
+		a.sessionManager.Put(ctx, "flash", fmt.Sprintf("Login with %s was cancelled.", provider.DisplayName))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	claims, err := provider.client.Exchange(ctx, query.Get("code"), nonce, verifier)
+	if err != nil {
+		a.logger.WarnContext(ctx, "oidc login failed", "provider", provider.Name, "error", err)
+		a.sessionManager.Put(ctx, "flash", fmt.Sprintf("Login with %s failed, please try again.", provider.DisplayName))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	id, err := linkOIDCIdentity(a.users, a.identities, provider, claims)
+	if err != nil {
+		var loginErr oidcLoginError
+		if errors.As(err, &loginErr) {
+			a.sessionManager.Put(ctx, "flash", string(loginErr))
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			a.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := a.users.Get(id)
+	if err != nil {
+		a.serverError(w, r, err)
+		return
+	}
+	if user.Disabled {
+		a.sessionManager.Put(ctx, "flash", "Your account has been disabled.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	a.loginWithSecondFactor(w, r, id, false)
+}
+
+// oidcLoginError - a reason the identity can't be used to log in that is shown to the user
+type oidcLoginError string
+
+func (e oidcLoginError) Error() string {
+	return string(e)
+}
+
+// oidcUsers and oidcIdentities - the parts of the user and identity models linkOIDCIdentity needs
+type oidcUsers interface {
+	GetByEmail(email string) (*models.User, error)
+	Provision(name, email string) (int, error)
+}
+
+type oidcIdentities interface {
+	UserID(issuer, subject string) (int, error)
+	Insert(userID int, issuer, subject string) error
+}
+
+// linkOIDCIdentity - finds the user linked to the identity. Unknown identities are linked to the user with the same
+// email address if both the provider and the user verified it, or get a new user if the provider allows
+// auto-provisioning.
+func linkOIDCIdentity(users oidcUsers, identities oidcIdentities, provider *oidcProvider, claims *oidc.Claims) (int, error) {
+	id, err := identities.UserID(claims.Issuer, claims.Subject)
+	if err == nil || !errors.Is(err, models.ErrNoRecord) {
+		return id, err
+	}
+
+	// the email address is all we know about the user, it can only be trusted if the provider verified it
+	if claims.Email == "" || !claims.EmailVerified {
+		return 0, oidcLoginError(fmt.Sprintf("%s did not confirm your email address, so it can't be linked to an account.", provider.DisplayName))
+	}
+
+	user, err := users.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		// anyone can sign up with an address they don't own, linking the identity to an account whose address
+		// was never confirmed would let whoever created it keep access through their password and tokens
+		if !user.EmailVerified() {
+			return 0, oidcLoginError(fmt.Sprintf("The account for %s has not confirmed its email address yet, please log in with your password and confirm it first.", claims.Email))
+		}
+		id = user.ID
+	case !errors.Is(err, models.ErrNoRecord):
+		return 0, err
+	case !provider.AutoProvision:
+		return 0, oidcLoginError(fmt.Sprintf("There is no account for %s yet, please sign up first.", claims.Email))
+	default:
+		name := strings.TrimSpace(claims.Name)
+		if name == "" {
+			name, _, _ = strings.Cut(claims.Email, "@")
+		}
+		id, err = users.Provision(name, claims.Email)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = identities.Insert(id, claims.Issuer, claims.Subject)
+	if errors.Is(err, models.ErrConflict) {
+		// a concurrent login linked the identity first
+		return identities.UserID(claims.Issuer, claims.Subject)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/oidc"
+	"github.com/danyelkeddah/snippetbox/internal/oidc/oidctest"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type fakeUsers struct {
+	users       map[string]*models.User
+	nextID      int
+	provisioned []string
+}
+
+func (f *fakeUsers) GetByEmail(email string) (*models.User, error) {
+	user, ok := f.users[email]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	return user, nil
+}
+
+func (f *fakeUsers) Provision(name, email string) (int, error) {
+	f.nextID++
+	f.users[email] = &models.User{ID: f.nextID, Name: name, Email: email, EmailVerifiedAt: time.Now()}
+	f.provisioned = append(f.provisioned, name)
+	return f.nextID, nil
+}
+
+type fakeIdentities struct {
+	links map[string]int
+}
+
+func (f *fakeIdentities) UserID(issuer, subject string) (int, error) {
+	id, ok := f.links[issuer+" "+subject]
+	if !ok {
+		return 0, models.ErrNoRecord
+	}
+	return id, nil
+}
+
+func (f *fakeIdentities) Insert(userID int, issuer, subject string) error {
+	if _, ok := f.links[issuer+" "+subject]; ok {
+		return models.ErrConflict
+	}
+	f.links[issuer+" "+subject] = userID
+	return nil
+}
+
+// oidcLogin - runs the whole login flow against the fake provider and returns the verified claims
+func oidcLogin(t *testing.T, srv *oidctest.Server, user oidctest.User) *oidc.Claims {
+	t.Helper()
+
+	srv.SetUser(user)
+	provider := oidc.New(oidc.Config{
+		Issuer:       srv.URL,
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  "http://localhost:4000/user/login/oidc/fake/callback",
+	}, nil)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := provider.Exchange(context.Background(), location.Query().Get("code"), "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestLinkOIDCIdentity(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	verified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		identity      oidctest.User
+		autoProvision bool
+		// linked is the user the identity is already linked to, 0 if it is new
+		linked   int
+		accounts []*models.User
+		wantID   int
+		// wantLoginErr is set when the user is sent back to the login page
+		wantLoginErr  bool
+		wantProvision string
+	}{
+		{
+			name:     "linked identity",
+			identity: oidctest.User{Subject: "alice", Email: "alice@example.com", EmailVerified: true},
+			linked:   7,
+			wantID:   7,
+		},
+		{
+			name:     "verified email links to verified account",
+			identity: oidctest.User{Subject: "alice", Email: "alice@example.com", EmailVerified: true},
+			accounts: []*models.User{{ID: 3, Email: "alice@example.com", EmailVerifiedAt: verified}},
+			wantID:   3,
+		},
+		{
+			name:         "verified email does not link to unverified account",
+			identity:     oidctest.User{Subject: "alice", Email: "alice@example.com", EmailVerified: true},
+			accounts:     []*models.User{{ID: 3, Email: "alice@example.com"}},
+			wantLoginErr: true,
+		},
+		{
+			name:          "unverified email is refused",
+			identity:      oidctest.User{Subject: "alice", Email: "alice@example.com"},
+			autoProvision: true,
+			accounts:      []*models.User{{ID: 3, Email: "alice@example.com", EmailVerifiedAt: verified}},
+			wantLoginErr:  true,
+		},
+		{
+			name:         "unknown email without auto-provisioning",
+			identity:     oidctest.User{Subject: "bob", Email: "bob@example.com", EmailVerified: true},
+			wantLoginErr: true,
+		},
+		{
+			name:          "auto-provisioning",
+			identity:      oidctest.User{Subject: "bob", Email: "bob@example.com", EmailVerified: true, Name: " Bob "},
+			autoProvision: true,
+			wantID:        101,
+			wantProvision: "Bob",
+		},
+		{
+			name:          "auto-provisioning without a name",
+			identity:      oidctest.User{Subject: "bob", Email: "bob@example.com", EmailVerified: true},
+			autoProvision: true,
+			wantID:        101,
+			wantProvision: "bob",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := oidcLogin(t, srv, tt.identity)
+
+			users := &fakeUsers{users: map[string]*models.User{}, nextID: 100}
+			for _, account := range tt.accounts {
+				users.users[account.Email] = account
+			}
+			identities := &fakeIdentities{links: map[string]int{}}
+			if tt.linked != 0 {
+				identities.links[srv.URL+" "+tt.identity.Subject] = tt.linked
+			}
+			provider := &oidcProvider{Name: "fake", DisplayName: "Fake SSO", AutoProvision: tt.autoProvision}
+
+			id, err := linkOIDCIdentity(users, identities, provider, claims)
+
+			var loginErr oidcLoginError
+			if tt.wantLoginErr {
+				if !errors.As(err, &loginErr) {
+					t.Fatalf("got error %v; want an oidcLoginError", err)
+				}
+				if len(identities.links) != 0 || len(users.provisioned) != 0 {
+					t.Errorf("got links %v and provisioned %v; want none", identities.links, users.provisioned)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if id != tt.wantID {
+				t.Errorf("got user %d; want %d", id, tt.wantID)
+			}
+			if linked := identities.links[srv.URL+" "+tt.identity.Subject]; linked != tt.wantID {
+				t.Errorf("got identity linked to %d; want %d", linked, tt.wantID)
+			}
+			if tt.wantProvision != "" && (len(users.provisioned) != 1 || users.provisioned[0] != tt.wantProvision) {
+				t.Errorf("got provisioned %v; want %q", users.provisioned, tt.wantProvision)
+			}
+		})
+	}
+}
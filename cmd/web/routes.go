@@ -1,7 +1,7 @@
 package main
 
 import (
-	"github.com/danyelkeddah/snippetbox/ui"
+	"github.com/danyelkeddah/snippetbox/internal/models"
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 	"net/http"
@@ -10,28 +10,112 @@ import (
 func (a *application) routes() http.Handler {
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		a.notFound(w)
+		a.notFound(w, r)
 	})
 
-	fileServer := http.FileServer(http.FS(ui.Files))
+	// handle - registers the route with its pattern so the request metrics can be labelled with it
+	handle := func(method, pattern string, handler http.Handler) {
+		router.Handler(method, pattern, withRoute(pattern, handler))
+	}
 
-	router.Handler(http.MethodGet, "/static/*filepath", fileServer)
-	dynamic := alice.New(a.sessionManager.LoadAndSave, noSurf, a.authenticate)
-	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(a.home))
-	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(a.snippetView))
+	fileServer := a.staticFiles()
+	if a.dev {
+		fileServer = noCache(fileServer)
+	}
+
+	handle(http.MethodGet, "/static/*filepath", fileServer)
+
+	// probes for the orchestrator, they don't need sessions or CSRF protection
+	handle(http.MethodGet, "/healthz", http.HandlerFunc(a.healthz))
+	handle(http.MethodGet, "/readyz", http.HandlerFunc(a.readyz))
+
+	// everything except static files and the probes counts against the global limit per IP address,
+	// a page load fetches many assets and the orchestrator polls the probes from a single address
+	limited := alice.New(a.rateLimit(a.limiters.global))
+
+	// browsers send the reports without cookies, so it can't be behind sessions or CSRF protection
+	handle(http.MethodPost, "/csp-report", limited.ThenFunc(a.cspReport))
+
+	dynamic := limited.Append(a.sessionManager.LoadAndSave, a.sessionTimeouts, a.noSurf, a.authenticate)
+	handle(http.MethodGet, "/", dynamic.ThenFunc(a.home))
+	handle(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(a.snippetView))
+	handle(http.MethodGet, "/snippet/raw/:id", limited.ThenFunc(a.snippetRaw))
 	protected := dynamic.Append(a.requiredAuthentication)
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(a.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(a.snippetCreatePost))
+	verified := protected.Append(a.requireVerifiedEmail)
+	handle(http.MethodGet, "/snippet/create", verified.ThenFunc(a.snippetCreate))
+	handle(http.MethodPost, "/snippet/create", verified.Append(a.rateLimit(a.limiters.create)).ThenFunc(a.snippetCreatePost))
+
+	// form submissions that could be abused for guessing passwords or sending emails are limited more strictly
+	limitedAuth := dynamic.Append(a.rateLimit(a.limiters.auth))
 
 	// Authentication routes
-	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(a.userSignup))
-	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(a.userSignupPost))
+	handle(http.MethodGet, "/user/signup", dynamic.ThenFunc(a.userSignup))
+	handle(http.MethodPost, "/user/signup", limitedAuth.ThenFunc(a.userSignupPost))
+
+	handle(http.MethodGet, "/user/login", dynamic.ThenFunc(a.userLogin))
+	handle(http.MethodPost, "/user/login", limitedAuth.ThenFunc(a.userLoginPost))
+	handle(http.MethodGet, "/user/login/2fa", dynamic.ThenFunc(a.userLoginTwoFactor))
+	handle(http.MethodPost, "/user/login/2fa", limitedAuth.ThenFunc(a.userLoginTwoFactorPost))
+
+	handle(http.MethodGet, "/user/login/oidc/:provider", limitedAuth.ThenFunc(a.userLoginOIDC))
+	handle(http.MethodGet, "/user/login/oidc/:provider/callback", limitedAuth.ThenFunc(a.userLoginOIDCCallback))
+
+	handle(http.MethodPost, "/user/logout", protected.ThenFunc(a.userLogoutPost))
+
+	handle(http.MethodGet, "/user/password/reset", dynamic.ThenFunc(a.userPasswordReset))
+	handle(http.MethodPost, "/user/password/reset", limitedAuth.ThenFunc(a.userPasswordResetPost))
+	handle(http.MethodGet, "/user/password/reset/:token", dynamic.ThenFunc(a.userPasswordResetConfirm))
+	handle(http.MethodPost, "/user/password/reset/:token", limitedAuth.ThenFunc(a.userPasswordResetConfirmPost))
+
+	handle(http.MethodGet, "/user/verify/:token", dynamic.ThenFunc(a.userVerifyEmail))
+	handle(http.MethodPost, "/user/verify-resend", protected.Append(a.rateLimit(a.limiters.auth)).ThenFunc(a.userVerifyEmailResendPost))
+
+	// Account routes
+	handle(http.MethodGet, "/account/view", protected.ThenFunc(a.accountView))
+	handle(http.MethodGet, "/account/password/update", protected.ThenFunc(a.accountPasswordUpdate))
+	handle(http.MethodPost, "/account/password/update", protected.ThenFunc(a.accountPasswordUpdatePost))
+	handle(http.MethodGet, "/account/delete", protected.ThenFunc(a.accountDelete))
+	handle(http.MethodPost, "/account/delete", protected.Append(a.rateLimit(a.limiters.auth)).ThenFunc(a.accountDeletePost))
+	handle(http.MethodGet, "/account/2fa/enroll", protected.ThenFunc(a.accountTwoFactorEnroll))
+	handle(http.MethodPost, "/account/2fa/enroll", protected.ThenFunc(a.accountTwoFactorEnrollPost))
+	handle(http.MethodGet, "/account/2fa/qr.png", protected.ThenFunc(a.accountTwoFactorQRCode))
+	handle(http.MethodPost, "/account/2fa/disable", protected.ThenFunc(a.accountTwoFactorDisablePost))
+	handle(http.MethodGet, "/account/sessions", protected.ThenFunc(a.accountSessions))
+	handle(http.MethodPost, "/account/sessions/revoke", protected.ThenFunc(a.accountSessionRevokePost))
+	handle(http.MethodPost, "/account/sessions/revoke-all", protected.ThenFunc(a.accountSessionRevokeAllPost))
+
+	handle(http.MethodPost, "/snippet/delete/:id", protected.ThenFunc(a.snippetDeletePost))
+	handle(http.MethodPost, "/snippet/report/:id", protected.ThenFunc(a.snippetReportPost))
 
-	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(a.userLogin))
-	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(a.userLoginPost))
+	// Admin routes, moderators can handle snippets and reports and admins can also manage users
+	moderator := protected.Append(a.requireRole(models.RoleModerator))
+	admin := protected.Append(a.requireRole(models.RoleAdmin))
+	handle(http.MethodGet, "/admin", moderator.ThenFunc(a.adminDashboard))
+	handle(http.MethodGet, "/admin/snippets", moderator.ThenFunc(a.adminSnippets))
+	handle(http.MethodPost, "/admin/snippets/:id/extend", moderator.ThenFunc(a.adminSnippetExtendPost))
+	handle(http.MethodPost, "/admin/snippets/:id/delete", moderator.ThenFunc(a.adminSnippetDeletePost))
+	handle(http.MethodGet, "/admin/reports", moderator.ThenFunc(a.adminReports))
+	handle(http.MethodPost, "/admin/reports/:id/dismiss", moderator.ThenFunc(a.adminReportDismissPost))
+	handle(http.MethodPost, "/admin/reports/:id/delete-snippet", moderator.ThenFunc(a.adminReportDeleteSnippetPost))
+	handle(http.MethodGet, "/admin/users", admin.ThenFunc(a.adminUsers))
+	handle(http.MethodPost, "/admin/users/:id/role", admin.ThenFunc(a.adminUserRolePost))
+	handle(http.MethodPost, "/admin/users/:id/disable", admin.Then(a.adminUserSetDisabledPost(true)))
+	handle(http.MethodPost, "/admin/users/:id/enable", admin.Then(a.adminUserSetDisabledPost(false)))
 
-	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(a.userLogoutPost))
+	// API routes for the command-line client, authenticated with bearer tokens instead of sessions
+	api := limited.Append(a.authenticateToken)
+	handle(http.MethodPost, "/api/tokens", api.Append(a.rateLimit(a.limiters.auth)).ThenFunc(a.apiCreateToken))
+	handle(http.MethodGet, "/api/snippets", api.ThenFunc(a.apiSnippetList))
+	handle(http.MethodGet, "/api/snippets/:id", api.ThenFunc(a.apiSnippetView))
+	apiProtected := api.Append(a.requireTokenAuthentication)
+	handle(http.MethodDelete, "/api/tokens", apiProtected.ThenFunc(a.apiDeleteToken))
+	handle(http.MethodPost, "/api/snippets", apiProtected.Append(a.rateLimit(a.limiters.create)).ThenFunc(a.apiSnippetCreate))
+	handle(http.MethodDelete, "/api/snippets/:id", apiProtected.ThenFunc(a.apiSnippetDelete))
 
-	standard := alice.New(a.recoverPanic, a.logRequest, secureHeaders)
+	standard := alice.New(requestID, a.logRequest, a.collectMetrics, a.recoverPanic)
+	if a.compress != nil {
+		standard = standard.Append(a.compress)
+	}
+	standard = standard.Append(a.secureHeaders)
 	return standard.Then(router)
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/alexedwards/scs/v2"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"github.com/danyelkeddah/snippetbox/internal/ratelimit"
+	"github.com/danyelkeddah/snippetbox/ui"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobalRateLimit(t *testing.T) {
+	// nothing listens on the address, the routes used here don't reach the database
+	db, err := sql.Open("mysql", "user:pass@tcp(127.0.0.1:1)/snippetbox?parseTime=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	a := &application{
+		logger:         logger,
+		accessLog:      &accessLogger{format: "json", records: logger, out: io.Discard, logger: logger},
+		sessionManager: scs.New(),
+		metrics:        newAppMetrics(db, &models.SessionModel{DB: db}),
+		db:             db,
+		uiFiles:        ui.Files,
+		limiters:       limiters{global: ratelimit.New(ratelimit.Rate{Limit: 1, Per: time.Hour})},
+	}
+	routes := a.routes()
+
+	request := func(method, target string) int {
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader("{}")))
+		return rr.Code
+	}
+
+	// the probes and static files don't use up the limit
+	for i := 0; i < 3; i++ {
+		if code := request(http.MethodGet, "/healthz"); code != http.StatusOK {
+			t.Errorf("GET /healthz: got status %d; want %d", code, http.StatusOK)
+		}
+		if code := request(http.MethodGet, "/static/css/main.css"); code != http.StatusOK {
+			t.Errorf("GET /static/css/main.css: got status %d; want %d", code, http.StatusOK)
+		}
+	}
+
+	if code := request(http.MethodPost, "/csp-report"); code == http.StatusTooManyRequests {
+		t.Errorf("POST /csp-report: got status %d on the first request", code)
+	}
+	if code := request(http.MethodPost, "/csp-report"); code != http.StatusTooManyRequests {
+		t.Errorf("POST /csp-report: got status %d; want %d", code, http.StatusTooManyRequests)
+	}
+	if code := request(http.MethodGet, "/healthz"); code != http.StatusOK {
+		t.Errorf("GET /healthz after the limit: got status %d; want %d", code, http.StatusOK)
+	}
+}
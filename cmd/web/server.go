@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serve - runs the servers until SIGINT or SIGTERM. On shutdown /readyz starts failing, after drainDelay
+// the servers stop accepting connections and wait up to timeout for running requests and background tasks.
+// metricsSrv is nil when the metrics endpoint is disabled.
+func (a *application) serve(srv, metricsSrv *http.Server, drainDelay, timeout time.Duration) error {
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		a.logger.Info("shutting down server", "signal", s.String())
+		a.shuttingDown.Store(true)
+		// load balancers need a moment to notice the failing readiness probe and stop sending requests
+		time.Sleep(drainDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+		if metricsSrv != nil {
+			err = errors.Join(err, metricsSrv.Shutdown(ctx))
+		}
+
+		a.logger.Info("waiting for background tasks")
+		done := make(chan struct{})
+		go func() {
+			a.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err = errors.Join(err, errors.New("background tasks did not finish before the shutdown timeout"))
+		}
+
+		shutdownErr <- err
+	}()
+
+	if metricsSrv != nil {
+		go func() {
+			a.logger.Info("starting metrics server", "addr", metricsSrv.Addr)
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				fatal(a.logger, err)
+			}
+		}()
+	}
+
+	a.logger.Info("starting server", "addr", srv.Addr)
+	err := srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	// ListenAndServeTLS returns as soon as Shutdown is called, the running requests are still being handled
+	err = <-shutdownErr
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("stopped server", "addr", srv.Addr)
+	return nil
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"github.com/danyelkeddah/snippetbox/internal/models"
-	"github.com/danyelkeddah/snippetbox/ui"
 	"html/template"
 	"io/fs"
 	"path/filepath"
@@ -13,10 +12,42 @@ type templateData struct {
 	CurrentYear     int
 	Snippet         *models.Snippet
 	Snippets        []*models.Snippet
+	User            *models.User
 	Form            any
 	Flash           string
 	IsAuthenticated bool
-	CSRFToken       string
+	// AuthenticatedUser is the logged in user or nil, pages use it to show actions depending on the role
+	AuthenticatedUser *models.User
+	CSRFToken         string
+	CSPNonce          string
+	Token             string
+	TwoFactorSecret   string
+	RecoveryCodes     []string
+	Sessions          []*models.Session
+	// CurrentSessionID marks the session making the request in the list of sessions
+	CurrentSessionID string
+	CanDeleteSnippet bool
+	Users            []*models.User
+	Roles            []string
+	Reports          []*models.Report
+	// Query is the search term of admin lists
+	Query string
+	Stats *adminStats
+	// OIDCProviders are offered as alternatives to the password on the login page
+	OIDCProviders []*oidcProvider
+	Error         *errorPage
+}
+
+// errorPage - what the error page shows for 4xx and 5xx responses
+type errorPage struct {
+	Status  int
+	Title   string
+	Message string
+	// RequestID lets users refer to the log record of a failed request
+	RequestID string
+	// Detail and Stack describe the cause of the error, they are only set in -dev mode
+	Detail string
+	Stack  string
 }
 
 func humanDate(t time.Time) string {
@@ -27,10 +58,16 @@ var functions = template.FuncMap{
 	"humanDate": humanDate,
 }
 
-func NewTemplateCache() (map[string]*template.Template, error) {
+// NewTemplateCache - parses the templates from fsys, which is ui.Files unless the templates are read from disk in -dev mode,
+// the asset function links to the static files in assets
+func NewTemplateCache(fsys fs.FS, assets *assetTable) (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
+	funcs := template.FuncMap{"asset": assets.url}
+	for name, fn := range functions {
+		funcs[name] = fn
+	}
 	//pages, err := filepath.Glob("./ui/html/pages/*.go.html")
-	pages, err := fs.Glob(ui.Files, "html/pages/*.go.html")
+	pages, err := fs.Glob(fsys, "html/pages/*.go.html")
 	if err != nil {
 		return nil, err
 	}
@@ -44,7 +81,7 @@ func NewTemplateCache() (map[string]*template.Template, error) {
 			page,
 		}
 
-		ts, err := template.New(name).Funcs(functions).ParseFS(ui.Files, patterns...)
+		ts, err := template.New(name).Funcs(funcs).ParseFS(fsys, patterns...)
 
 		if err != nil {
 			return nil, err
@@ -52,5 +89,23 @@ func NewTemplateCache() (map[string]*template.Template, error) {
 
 		cache[name] = ts
 	}
+
+	// admin pages use their own layout and are rendered as "admin/<page>"
+	adminPages, err := fs.Glob(fsys, "html/admin/*.go.html")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, page := range adminPages {
+		name := filepath.Base(page)
+
+		ts, err := template.New(name).Funcs(funcs).ParseFS(fsys, "html/admin.go.html", page)
+		if err != nil {
+			return nil, err
+		}
+
+		cache["admin/"+name] = ts
+	}
+
 	return cache, nil
 }
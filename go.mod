@@ -1,14 +1,15 @@
 module github.com/danyelkeddah/snippetbox
 
-go 1.19
+go 1.21
 
 require (
-	github.com/alexedwards/scs/mysqlstore v0.0.0-20221206171621-0f0849773278 // indirect
-	github.com/alexedwards/scs/v2 v2.5.0 // indirect
-	github.com/go-playground/form/v4 v4.2.0 // indirect
-	github.com/go-sql-driver/mysql v1.7.0 // indirect
-	github.com/julienschmidt/httprouter v1.3.0 // indirect
-	github.com/justinas/alice v1.2.0 // indirect
-	github.com/justinas/nosurf v1.1.1 // indirect
-	golang.org/x/crypto v0.4.0 // indirect
+	github.com/alexedwards/scs/mysqlstore v0.0.0-20221206171621-0f0849773278
+	github.com/alexedwards/scs/v2 v2.5.0
+	github.com/go-playground/form/v4 v4.2.0
+	github.com/go-sql-driver/mysql v1.7.0
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/justinas/alice v1.2.0
+	github.com/justinas/nosurf v1.1.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.4.0
 )
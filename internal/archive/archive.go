@@ -0,0 +1,194 @@
+// Package archive reads and writes instance exports as JSON Lines.
+//
+// The first line is a manifest, every following line holds exactly one record:
+//
+//	{"type":"manifest","manifest":{"version":1,"exported_at":"...","users":2,"snippets":10}}
+//	{"type":"user","user":{"id":1,"name":"Alice",...}}
+//	{"type":"snippet","snippet":{"id":1,"user_id":1,...}}
+//
+// Users always come before snippets so that snippet owners exist when importing.
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"io"
+	"time"
+)
+
+// Version is incremented whenever the record layout changes in an incompatible way.
+const Version = 1
+
+type Manifest struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Users      int       `json:"users"`
+	Snippets   int       `json:"snippets"`
+}
+
+type Archive struct {
+	Manifest Manifest
+	Users    []*models.User
+	Snippets []*models.Snippet
+}
+
+type user struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+	Disabled     bool      `json:"disabled"`
+	// EmailVerifiedAt is omitted for unverified users
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// Role is missing from archives written before roles existed, those users import as regular users
+	Role string `json:"role,omitempty"`
+}
+
+type snippet struct {
+	ID      int       `json:"id"`
+	UserID  int       `json:"user_id,omitempty"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+type record struct {
+	Type     string    `json:"type"`
+	Manifest *Manifest `json:"manifest,omitempty"`
+	User     *user     `json:"user,omitempty"`
+	Snippet  *snippet  `json:"snippet,omitempty"`
+}
+
+func Write(w io.Writer, users []*models.User, snippets []*models.Snippet) error {
+	enc := json.NewEncoder(w)
+
+	manifest := &Manifest{
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Users:      len(users),
+		Snippets:   len(snippets),
+	}
+	err := enc.Encode(record{Type: "manifest", Manifest: manifest})
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		var emailVerifiedAt *time.Time
+		if u.EmailVerified() {
+			t := u.EmailVerifiedAt.UTC()
+			emailVerifiedAt = &t
+		}
+
+		err = enc.Encode(record{Type: "user", User: &user{
+			ID:              u.ID,
+			Name:            u.Name,
+			Email:           u.Email,
+			PasswordHash:    string(u.Password),
+			CreatedAt:       u.CreatedAt.UTC(),
+			Disabled:        u.Disabled,
+			EmailVerifiedAt: emailVerifiedAt,
+			Role:            u.Role,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range snippets {
+		err = enc.Encode(record{Type: "snippet", Snippet: &snippet{
+			ID:      s.ID,
+			UserID:  s.UserID,
+			Title:   s.Title,
+			Content: s.Content,
+			Created: s.Created.UTC(),
+			Expires: s.Expires.UTC(),
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Read parses a complete archive, it fails before anything is imported if the archive is truncated or malformed.
+func Read(r io.Reader) (*Archive, error) {
+	scanner := bufio.NewScanner(r)
+	// snippets can be large, allow lines up to 16MB
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	a := &Archive{}
+	line := 0
+	hasManifest := false
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var rec record
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if !hasManifest {
+			if rec.Type != "manifest" || rec.Manifest == nil {
+				return nil, fmt.Errorf("line %d: archive does not start with a manifest", line)
+			}
+			if rec.Manifest.Version != Version {
+				return nil, fmt.Errorf("line %d: unsupported archive version %d", line, rec.Manifest.Version)
+			}
+			a.Manifest = *rec.Manifest
+			hasManifest = true
+			continue
+		}
+
+		switch {
+		case rec.Type == "user" && rec.User != nil:
+			u := &models.User{
+				ID:        rec.User.ID,
+				Name:      rec.User.Name,
+				Email:     rec.User.Email,
+				Password:  []byte(rec.User.PasswordHash),
+				CreatedAt: rec.User.CreatedAt,
+				Disabled:  rec.User.Disabled,
+				Role:      rec.User.Role,
+			}
+			if rec.User.EmailVerifiedAt != nil {
+				u.EmailVerifiedAt = *rec.User.EmailVerifiedAt
+			}
+			a.Users = append(a.Users, u)
+		case rec.Type == "snippet" && rec.Snippet != nil:
+			a.Snippets = append(a.Snippets, &models.Snippet{
+				ID:      rec.Snippet.ID,
+				UserID:  rec.Snippet.UserID,
+				Title:   rec.Snippet.Title,
+				Content: rec.Snippet.Content,
+				Created: rec.Snippet.Created,
+				Expires: rec.Snippet.Expires,
+			})
+		default:
+			return nil, fmt.Errorf("line %d: unknown record type %q", line, rec.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !hasManifest {
+		return nil, errors.New("archive is empty")
+	}
+	if len(a.Users) != a.Manifest.Users || len(a.Snippets) != a.Manifest.Snippets {
+		return nil, fmt.Errorf("archive is incomplete: manifest lists %d users and %d snippets, found %d and %d",
+			a.Manifest.Users, a.Manifest.Snippets, len(a.Users), len(a.Snippets))
+	}
+
+	return a, nil
+}
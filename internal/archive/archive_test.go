@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"bytes"
+	"github.com/danyelkeddah/snippetbox/internal/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	users := []*models.User{
+		{
+			ID:              1,
+			Name:            "Alice",
+			Email:           "alice@example.com",
+			Password:        []byte("$2a$12$alice"),
+			CreatedAt:       created,
+			EmailVerifiedAt: created.Add(time.Hour),
+			Role:            models.RoleAdmin,
+		},
+		{
+			ID:        2,
+			Name:      "Bob",
+			Email:     "bob@example.com",
+			Password:  []byte("$2a$12$bob"),
+			CreatedAt: created,
+			Disabled:  true,
+			Role:      models.RoleModerator,
+		},
+		{
+			ID:        3,
+			Name:      "Carol",
+			Email:     "carol@example.com",
+			Password:  []byte("$2a$12$carol"),
+			CreatedAt: created,
+			Role:      models.RoleUser,
+		},
+	}
+	snippets := []*models.Snippet{
+		{ID: 1, UserID: 1, Title: "Title", Content: "Content\nwith lines", Created: created, Expires: created.AddDate(0, 0, 7)},
+		{ID: 2, Title: "Anonymous", Content: "Content", Created: created, Expires: created.AddDate(1, 0, 0)},
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, users, snippets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Manifest.Version != Version || a.Manifest.Users != len(users) || a.Manifest.Snippets != len(snippets) {
+		t.Errorf("got manifest %+v", a.Manifest)
+	}
+	if !reflect.DeepEqual(a.Users, users) {
+		for i := range users {
+			t.Errorf("user %d: got %+v; want %+v", i, a.Users[i], users[i])
+		}
+	}
+	if !reflect.DeepEqual(a.Snippets, snippets) {
+		for i := range snippets {
+			t.Errorf("snippet %d: got %+v; want %+v", i, a.Snippets[i], snippets[i])
+		}
+	}
+}
+
+func TestReadWithoutRole(t *testing.T) {
+	input := `{"type":"manifest","manifest":{"version":1,"exported_at":"2024-03-01T12:00:00Z","users":1,"snippets":0}}
+{"type":"user","user":{"id":1,"name":"Alice","email":"alice@example.com","password_hash":"x","created_at":"2024-03-01T12:00:00Z","disabled":false}}
+`
+	a, err := Read(bytes.NewBufferString(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the role is left empty, UserModel.Restore turns it into a regular user
+	if a.Users[0].Role != "" || a.Users[0].EmailVerified() {
+		t.Errorf("got role %q and verified %v; want no role and unverified", a.Users[0].Role, a.Users[0].EmailVerified())
+	}
+}
+
+func TestReadIncomplete(t *testing.T) {
+	input := `{"type":"manifest","manifest":{"version":1,"exported_at":"2024-03-01T12:00:00Z","users":2,"snippets":0}}
+{"type":"user","user":{"id":1,"name":"Alice","email":"alice@example.com","password_hash":"x","created_at":"2024-03-01T12:00:00Z","disabled":false}}
+`
+	_, err := Read(bytes.NewBufferString(input))
+	if err == nil {
+		t.Error("got no error for a truncated archive")
+	}
+}
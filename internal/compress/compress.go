@@ -0,0 +1,302 @@
+// Package compress implements a middleware that compresses responses with the best content coding the client
+// accepts. Gzip and deflate are provided, other codings such as Brotli can be added as an Encoder.
+//
+// Responses are buffered until MinSize bytes were written, so small responses are sent as they are.
+// Responses that already have a Content-Encoding, partial content and types that don't compress well are
+// passed through.
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder is a content coding.
+type Encoder struct {
+	// Name is the token used in Accept-Encoding and Content-Encoding, e.g. gzip
+	Name string
+	// NewWriter returns a writer compressing into w, Close must write out the remaining data.
+	// Writers with a Reset(io.Writer) method are reused.
+	NewWriter func(w io.Writer) io.WriteCloser
+}
+
+var Gzip = Encoder{
+	Name: "gzip",
+	NewWriter: func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+}
+
+var Deflate = Encoder{
+	Name: "deflate",
+	NewWriter: func(w io.Writer) io.WriteCloser {
+		// the error is only set for invalid levels
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	},
+}
+
+// DefaultContentTypes are the media types that are compressed if Config.ContentTypes is empty.
+var DefaultContentTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+}
+
+type Config struct {
+	// Encoders in the order the server prefers them when the client accepts several equally
+	Encoders []Encoder
+	// MinSize is the size in bytes below which responses are not compressed
+	MinSize int
+	// ContentTypes are the media types that are compressed, DefaultContentTypes if empty
+	ContentTypes []string
+}
+
+type resetter interface {
+	Reset(w io.Writer)
+}
+
+// Middleware returns the compression middleware for c.
+func Middleware(c Config) func(http.Handler) http.Handler {
+	if c.ContentTypes == nil {
+		c.ContentTypes = DefaultContentTypes
+	}
+	types := map[string]bool{}
+	for _, t := range c.ContentTypes {
+		types[t] = true
+	}
+
+	pools := map[string]*sync.Pool{}
+	for _, e := range c.Encoders {
+		pools[e.Name] = &sync.Pool{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the response depends on Accept-Encoding even if this client does not accept any coding
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoder, ok := Negotiate(r, c.Encoders)
+			if !ok || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &responseWriter{
+				ResponseWriter: w,
+				encoder:        encoder,
+				pool:           pools[encoder.Name],
+				minSize:        c.MinSize,
+				types:          types,
+			}
+			next.ServeHTTP(cw, r)
+			// a panic is left to the recovering middleware, the buffered part of the response is dropped
+			cw.Close()
+		})
+	}
+}
+
+// Negotiate returns the encoder with the highest quality in the Accept-Encoding header of r,
+// encoders earlier in the list win ties.
+func Negotiate(r *http.Request, encoders []Encoder) (Encoder, bool) {
+	header := r.Header.Get("Accept-Encoding")
+	if header == "" {
+		return Encoder{}, false
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.TrimSpace(key) == "q" {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err == nil {
+					q = parsed
+				}
+			}
+		}
+		qualities[coding] = q
+	}
+
+	var best Encoder
+	bestQ := 0.0
+	for _, e := range encoders {
+		q, ok := qualities[e.Name]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// responseWriter buffers the start of the response to decide whether it is compressed.
+type responseWriter struct {
+	http.ResponseWriter
+	encoder Encoder
+	pool    *sync.Pool
+	minSize int
+	types   map[string]bool
+
+	status int
+	buf    []byte
+	// decided is set once the headers were sent, compressor is nil if the response is not compressed
+	decided    bool
+	compressor io.WriteCloser
+}
+
+func (cw *responseWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+	// informational responses are sent right away and don't end the header phase
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	if !bodyAllowed(status) {
+		cw.decide(false)
+	}
+}
+
+func (cw *responseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+		err := cw.start()
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been buffered, streamed responses are compressed if they are eligible.
+func (cw *responseWriter) Flush() {
+	if !cw.decided && cw.status != 0 {
+		cw.start()
+	}
+	if f, ok := cw.compressor.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter, e.g. to hijack the connection.
+func (cw *responseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close sends a response that stayed below the minimum size uncompressed and finishes a compressed one.
+func (cw *responseWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			// nothing was written, net/http sends an empty 200 response
+			return nil
+		}
+		return cw.flushBuffer(false)
+	}
+
+	if cw.compressor == nil {
+		return nil
+	}
+	err := cw.compressor.Close()
+	if _, ok := cw.compressor.(resetter); ok {
+		cw.pool.Put(cw.compressor)
+	}
+	cw.compressor = nil
+	return err
+}
+
+// start decides by the buffered data and the headers whether the response is compressed
+func (cw *responseWriter) start() error {
+	h := cw.Header()
+	if h.Get("Content-Type") == "" {
+		// the same sniffing net/http would do, it can't see the uncompressed data later
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+
+	compress := bodyAllowed(cw.status) &&
+		cw.status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == "" &&
+		cw.types[mediaType]
+
+	return cw.flushBuffer(compress)
+}
+
+func (cw *responseWriter) flushBuffer(compress bool) error {
+	cw.decide(compress)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// decide sends the headers, with the compression headers if compress is set
+func (cw *responseWriter) decide(compress bool) {
+	cw.decided = true
+
+	if compress {
+		h := cw.Header()
+		h.Set("Content-Encoding", cw.encoder.Name)
+		h.Del("Content-Length")
+		// the compressed body is a different representation, so a strong validator can't be kept
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		if w, ok := cw.pool.Get().(io.WriteCloser); ok {
+			w.(resetter).Reset(cw.ResponseWriter)
+			cw.compressor = w
+		} else {
+			cw.compressor = cw.encoder.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func bodyAllowed(status int) bool {
+	return status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,193 @@
+// Package lockout slows down password guessing by locking a key, such as an account or an IP address,
+// for an exponentially growing period once it has failed too many times.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps the failure count per key, MemoryStore and models.LoginAttemptModel implement it.
+type Store interface {
+	Get(key string) (failures int, lastFailure time.Time, err error)
+	// RecordFailure increments the count and returns the new one in a single atomic step,
+	// the count starts over if the last failure is older than window
+	RecordFailure(key string, window time.Duration) (failures int, lastFailure time.Time, err error)
+	// Forgive takes back one recorded failure
+	Forgive(key string) error
+	Reset(key string) error
+}
+
+type Limiter struct {
+	Store Store
+	// Threshold is the number of failures allowed before the key gets locked
+	Threshold int
+	// BaseDelay is the lockout after Threshold failures, it doubles with every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long failures are remembered, a key that did not fail for this long starts over
+	Window time.Duration
+}
+
+// Check returns how long the key stays locked, or 0 if it may try again.
+func (l *Limiter) Check(key string) (time.Duration, error) {
+	failures, lastFailure, err := l.Store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return l.remaining(failures, lastFailure), nil
+}
+
+// Attempt counts an attempt before the credentials are checked and returns how long the key is locked,
+// the attempt must only be made if it is 0. Every attempt counts as a failure until it is taken back with
+// Forgive or Succeed. Counting first means parallel attempts can't all pass the check before any of them failed.
+func (l *Limiter) Attempt(key string) (time.Duration, error) {
+	failures, lastFailure, err := l.Store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if locked := l.remaining(failures, lastFailure); locked > 0 {
+		return locked, nil
+	}
+	if !lastFailure.IsZero() && time.Since(lastFailure) > l.Window {
+		failures = 0
+	}
+
+	counted, lastFailure, err := l.Store.RecordFailure(key, l.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	// attempts running in parallel saw the same count, past the threshold only the first one after
+	// the lockout ran out may go ahead
+	if counted > l.Threshold && counted != failures+1 {
+		// refused attempts are not counted, just like attempts while the key is locked
+		err = l.Store.Forgive(key)
+		if err != nil {
+			return 0, err
+		}
+		if locked := l.remaining(counted, lastFailure); locked > 0 {
+			return locked, nil
+		}
+		return l.BaseDelay, nil
+	}
+
+	return 0, nil
+}
+
+// Forgive takes back an attempt that turned out not to be a failure, without forgetting earlier failures.
+func (l *Limiter) Forgive(key string) error {
+	return l.Store.Forgive(key)
+}
+
+// Succeed forgets previous failures of the key.
+func (l *Limiter) Succeed(key string) error {
+	return l.Store.Reset(key)
+}
+
+func (l *Limiter) remaining(failures int, lastFailure time.Time) time.Duration {
+	if failures < l.Threshold || time.Since(lastFailure) > l.Window {
+		return 0
+	}
+
+	delay := l.BaseDelay
+	for i := l.Threshold; i < failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.MaxDelay {
+		delay = l.MaxDelay
+	}
+
+	remaining := time.Until(lastFailure.Add(delay))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+}
+
+// MemoryStore keeps failures in process memory, they are lost on restart and not shared between instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	attempts  map[string]*attempts
+	retention time.Duration
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns a store that forgets keys which did not fail for longer than retention.
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{
+		attempts:  make(map[string]*attempts),
+		retention: retention,
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *MemoryStore) Get(key string) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.attempts[key]
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+	return a.failures, a.lastFailure, nil
+}
+
+func (m *MemoryStore) RecordFailure(key string, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	a, ok := m.attempts[key]
+	if !ok {
+		a = &attempts{}
+		m.attempts[key] = a
+	}
+	if now.Sub(a.lastFailure) > window {
+		a.failures = 0
+	}
+	a.failures++
+	a.lastFailure = now
+
+	return a.failures, a.lastFailure, nil
+}
+
+func (m *MemoryStore) Forgive(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a, ok := m.attempts[key]; ok && a.failures > 0 {
+		a.failures--
+	}
+	return nil
+}
+
+func (m *MemoryStore) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+	return nil
+}
+
+// sweep removes stale keys at most once a minute so the map can not grow without bounds.
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+
+	for key, a := range m.attempts {
+		if now.Sub(a.lastFailure) > m.retention {
+			delete(m.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package lockout
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newLimiter() *Limiter {
+	return &Limiter{
+		Store:     NewMemoryStore(time.Hour),
+		Threshold: 5,
+		BaseDelay: time.Minute,
+		MaxDelay:  time.Hour,
+		Window:    time.Hour,
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	l := newLimiter()
+
+	for i := 1; i <= l.Threshold; i++ {
+		locked, err := l.Attempt("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != 0 {
+			t.Fatalf("attempt %d: got locked for %v; want allowed", i, locked)
+		}
+	}
+
+	locked, err := l.Attempt("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked <= 0 || locked > l.BaseDelay {
+		t.Errorf("got locked for %v; want up to %v", locked, l.BaseDelay)
+	}
+
+	// attempts while locked are not counted
+	failures, _, err := l.Store.Get("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != l.Threshold {
+		t.Errorf("got %d failures; want %d", failures, l.Threshold)
+	}
+
+	err = l.Succeed("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locked, err = l.Attempt("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked != 0 {
+		t.Errorf("got locked for %v after Succeed; want allowed", locked)
+	}
+}
+
+func TestAttemptForgive(t *testing.T) {
+	l := newLimiter()
+
+	// successful attempts that are taken back never lock the key
+	for i := 0; i < 3*l.Threshold; i++ {
+		locked, err := l.Attempt("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != 0 {
+			t.Fatalf("attempt %d: got locked for %v; want allowed", i+1, locked)
+		}
+		err = l.Forgive("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAttemptConcurrent(t *testing.T) {
+	l := newLimiter()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locked, err := l.Attempt("key")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if locked == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := int(allowed.Load()); got != l.Threshold {
+		t.Errorf("got %d attempts through; want %d", got, l.Threshold)
+	}
+}
+
+func TestAttemptWindow(t *testing.T) {
+	l := newLimiter()
+	store := l.Store.(*MemoryStore)
+	store.attempts["key"] = &attempts{failures: 50, lastFailure: time.Now().Add(-2 * l.Window)}
+
+	// failures older than the window are forgotten
+	locked, err := l.Attempt("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if locked != 0 {
+		t.Errorf("got locked for %v; want allowed", locked)
+	}
+	if failures := store.attempts["key"].failures; failures != 1 {
+		t.Errorf("got %d failures; want 1", failures)
+	}
+}
+
+func TestAttemptConcurrentAfterLockout(t *testing.T) {
+	l := newLimiter()
+	store := l.Store.(*MemoryStore)
+	// the lockout after the threshold ran out, the next failure locks the key again
+	store.attempts["key"] = &attempts{failures: l.Threshold, lastFailure: time.Now().Add(-2 * l.BaseDelay)}
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locked, err := l.Attempt("key")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if locked == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 1 {
+		t.Errorf("got %d attempts through; want 1", got)
+	}
+}
@@ -0,0 +1,87 @@
+// Package mailer sends transactional emails such as password reset links.
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is implemented by every way of delivering a message, the application only depends on this interface.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// SMTP delivers messages through an SMTP server using PLAIN authentication when a username is set.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string
+}
+
+func (s *SMTP) Send(msg Message) error {
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	return smtp.SendMail(addr, auth, s.Sender, []string{msg.To}, format(s.Sender, msg))
+}
+
+// Log writes messages to a logger instead of sending them, it is meant for development.
+type Log struct {
+	Logger *log.Logger
+}
+
+func (l *Log) Send(msg Message) error {
+	l.Logger.Printf("email to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// Dir writes every message into its own .eml file so tests and developers can inspect what would have been sent.
+type Dir struct {
+	Path   string
+	Sender string
+	count  atomic.Int64
+}
+
+func (d *Dir) Send(msg Message) error {
+	err := os.MkdirAll(d.Path, 0o700)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%03d.eml", time.Now().UnixNano(), d.count.Add(1))
+	return os.WriteFile(filepath.Join(d.Path, name), format(d.Sender, msg), 0o600)
+}
+
+// format builds an RFC 5322 message with a plain text body.
+func format(sender string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", sender)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return b.Bytes()
+}
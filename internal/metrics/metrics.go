@@ -0,0 +1,317 @@
+// Package metrics implements counters, gauges and histograms exposed in the Prometheus text format (version 0.0.4).
+//
+// Metrics are created on a Registry and written out in the order they were registered:
+//
+//	reg := metrics.NewRegistry()
+//	requests := reg.NewCounterVec("http_requests_total", "Requests handled.", "route", "status")
+//	requests.With("/snippet/view/:id", "200").Inc()
+//	http.Handle("/metrics", reg)
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds in seconds used for request latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry is safe for concurrent use, it implements http.Handler to serve the metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	bw.Flush()
+}
+
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.kind)
+}
+
+// labelPairs formats the labels as {a="1",b="2"}, extra is appended as is, e.g. le="0.5" for histograms.
+func (d *desc) labelPairs(values []string, extra string) string {
+	if len(d.labels) == 0 && extra == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range d.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", label, escapeLabelValue(values[i]))
+	}
+	if extra != "" {
+		if len(d.labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extra)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Counter only goes up.
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add panics if v is negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters can't decrease")
+	}
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) get() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec is a set of counters partitioned by label values.
+type CounterVec struct {
+	desc
+	mu       sync.Mutex
+	counters map[string]*Counter
+	values   map[string][]string
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{
+		desc:     desc{name: name, help: help, kind: "counter", labels: labels},
+		counters: map[string]*Counter{},
+		values:   map[string][]string{},
+	}
+	r.register(name, v)
+	return v
+}
+
+// NewCounter returns a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// With returns the counter for the label values, which must be given in the order the labels were declared.
+func (v *CounterVec) With(values ...string) *Counter {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[key]
+	if !ok {
+		c = &Counter{}
+		v.counters[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+
+	v.mu.Lock()
+	keys := sortedKeys(v.counters)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(v.values[key], ""), formatFloat(v.counters[key].get()))
+	}
+	v.mu.Unlock()
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	desc
+	buckets    []float64
+	mu         sync.Mutex
+	histograms map[string]*Histogram
+	values     map[string][]string
+}
+
+// NewHistogramVec uses DefaultBuckets if buckets is nil, the upper bounds must be sorted.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	v := &HistogramVec{
+		desc:       desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets:    buckets,
+		histograms: map[string]*Histogram{},
+		values:     map[string][]string{},
+	}
+	r.register(name, v)
+	return v
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.histograms[key]
+	if !ok {
+		h = &Histogram{buckets: v.buckets, counts: make([]uint64, len(v.buckets))}
+		v.histograms[key] = h
+		v.values[key] = append([]string(nil), values...)
+	}
+	return h
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+
+	v.mu.Lock()
+	keys := sortedKeys(v.histograms)
+	for _, key := range keys {
+		values := v.values[key]
+		counts, count, sum := v.histograms[key].snapshot()
+		for i, upper := range v.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.labelPairs(values, fmt.Sprintf("le=\"%s\"", formatFloat(upper))), counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, v.labelPairs(values, `le="+Inf"`), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, v.labelPairs(values, ""), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, v.labelPairs(values, ""), count)
+	}
+	v.mu.Unlock()
+}
+
+type Histogram struct {
+	buckets []float64
+	mu      sync.Mutex
+	// counts are cumulative, counts[i] is the number of observations <= buckets[i]
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) snapshot() ([]uint64, uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]uint64(nil), h.counts...), h.count, h.sum
+}
+
+// funcMetric reads its value when the metrics are scraped.
+type funcMetric struct {
+	desc
+	fn func() (float64, error)
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn on every scrape, the sample is left out when fn fails.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() (float64, error)) {
+	r.register(name, &funcMetric{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+// NewCounterFunc is like NewGaugeFunc for values that only go up, such as totals kept by another package.
+func (r *Registry) NewCounterFunc(name, help string, fn func() (float64, error)) {
+	r.register(name, &funcMetric{desc: desc{name: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	value, err := m.fn()
+	if err != nil {
+		return
+	}
+	m.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(value))
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
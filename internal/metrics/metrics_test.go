@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func scrape(t *testing.T, reg *Registry) string {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	reg.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := rr.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("got content type %q", got)
+	}
+	return rr.Body.String()
+}
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := reg.NewCounterVec("http_requests_total", "Requests handled.", "route", "status")
+	requests.With("/snippet/view/:id", "200").Inc()
+	requests.With("/snippet/view/:id", "200").Add(2)
+	requests.With("/", "500").Inc()
+	reg.NewCounter("logins_total", "Successful logins.\nFrom \\ all providers.").Add(0.5)
+
+	reg.NewGaugeFunc("sessions_active", "Active sessions.", func() (float64, error) { return 42, nil })
+	reg.NewGaugeFunc("database_up", "Skipped while the database is down.", func() (float64, error) { return 0, errors.New("down") })
+
+	latency := reg.NewHistogramVec("request_duration_seconds", "Request latency.", []float64{0.1, 0.5, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.3, 2} {
+		latency.With("/").Observe(v)
+	}
+
+	want := `# HELP http_requests_total Requests handled.
+# TYPE http_requests_total counter
+http_requests_total{route="/snippet/view/:id",status="200"} 3
+http_requests_total{route="/",status="500"} 1
+# HELP logins_total Successful logins.\nFrom \\ all providers.
+# TYPE logins_total counter
+logins_total 0.5
+# HELP sessions_active Active sessions.
+# TYPE sessions_active gauge
+sessions_active 42
+# HELP request_duration_seconds Request latency.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/",le="0.1"} 2
+request_duration_seconds_bucket{route="/",le="0.5"} 3
+request_duration_seconds_bucket{route="/",le="1"} 3
+request_duration_seconds_bucket{route="/",le="+Inf"} 4
+request_duration_seconds_sum{route="/"} 2.45
+request_duration_seconds_count{route="/"} 4
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounterVec("errors_total", "Errors.", "message").With("say \"hi\"\nC:\\temp").Inc()
+
+	want := `# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total{message="say \"hi\"\nC:\\temp"} 1
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHistogramWithoutLabels(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.NewHistogramVec("size_bytes", "Response sizes.", []float64{100, 1000}).With()
+	h.Observe(100)
+	h.Observe(5000)
+
+	want := `# HELP size_bytes Response sizes.
+# TYPE size_bytes histogram
+size_bytes_bucket{le="100"} 1
+size_bytes_bucket{le="1000"} 1
+size_bytes_bucket{le="+Inf"} 2
+size_bytes_sum 5100
+size_bytes_count 2
+`
+	if got := scrape(t, reg); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestCounterDecrease(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("got no panic adding a negative value")
+		}
+	}()
+
+	NewRegistry().NewCounter("c", "C.").Add(-1)
+}
@@ -1,9 +1,37 @@
 package models
 
-import "errors"
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+)
 
 var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrConflict           = errors.New("models: conflicting record")
 )
+
+// checkRowsAffected returns ErrNoRecord when an UPDATE or DELETE did not match any row.
+func checkRowsAffected(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func isDuplicateEntry(err error) bool {
+	var mySQLError *mysql.MySQLError
+	return errors.As(err, &mySQLError) && mySQLError.Number == 1062
+}
+
+func isForeignKeyViolation(err error) bool {
+	var mySQLError *mysql.MySQLError
+	return errors.As(err, &mySQLError) && mySQLError.Number == 1452
+}
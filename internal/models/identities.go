@@ -0,0 +1,40 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// IdentityModel links users to their accounts at external identity providers,
+// an identity is the subject of an ID token together with the issuer that signed it.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+func (m *IdentityModel) UserID(issuer, subject string) (int, error) {
+	var userID int
+	statement := `SELECT user_id FROM snippetbox.user_identities WHERE issuer = ? AND subject = ?`
+	err := m.DB.QueryRow(statement, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Insert returns ErrConflict if the identity is already linked to a user.
+func (m *IdentityModel) Insert(userID int, issuer, subject string) error {
+	statement := `INSERT INTO snippetbox.user_identities (user_id, issuer, subject, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(statement, userID, issuer, subject)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return nil
+}
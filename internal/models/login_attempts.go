@@ -0,0 +1,84 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttemptModel stores failed login attempts so lockouts are shared between instances and survive restarts.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+func (l *LoginAttemptModel) Get(key string) (int, time.Time, error) {
+	var failures int
+	var lastFailure time.Time
+	statement := `SELECT failures, last_failure FROM snippetbox.login_attempts WHERE attempt_key = ?`
+	err := l.DB.QueryRow(statement, key).Scan(&failures, &lastFailure)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, nil
+		}
+		return 0, time.Time{}, err
+	}
+
+	return failures, lastFailure, nil
+}
+
+// RecordFailure counts the failure in a single statement so concurrent attempts each get their own count,
+// LAST_INSERT_ID(expr) hands the new count of an updated row back to the client.
+func (l *LoginAttemptModel) RecordFailure(key string, window time.Duration) (int, time.Time, error) {
+	statement := `INSERT INTO snippetbox.login_attempts (attempt_key, failures, last_failure) VALUES (?, 1, UTC_TIMESTAMP())
+		ON DUPLICATE KEY UPDATE
+			failures = LAST_INSERT_ID(IF(last_failure < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND), 1, failures + 1)),
+			last_failure = UTC_TIMESTAMP()`
+	result, err := l.DB.Exec(statement, key, int(window.Seconds()))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	now := time.Now()
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	// one affected row means the row was inserted
+	if rowsAffected == 1 {
+		return 1, now, nil
+	}
+
+	failures, err := result.LastInsertId()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return int(failures), now, nil
+}
+
+func (l *LoginAttemptModel) Forgive(key string) error {
+	statement := `UPDATE snippetbox.login_attempts SET failures = failures - 1 WHERE attempt_key = ? AND failures > 0`
+	_, err := l.DB.Exec(statement, key)
+
+	return err
+}
+
+func (l *LoginAttemptModel) Reset(key string) error {
+	statement := `DELETE FROM snippetbox.login_attempts WHERE attempt_key = ?`
+	_, err := l.DB.Exec(statement, key)
+
+	return err
+}
+
+// DeleteStale removes attempts that failed last before the given duration.
+func (l *LoginAttemptModel) DeleteStale(olderThan time.Duration) (int, error) {
+	statement := `DELETE FROM snippetbox.login_attempts WHERE last_failure < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)`
+	result, err := l.DB.Exec(statement, int(olderThan.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return int(n), err
+}
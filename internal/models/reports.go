@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Report struct {
+	ID           int
+	SnippetID    int
+	SnippetTitle string
+	UserID       int
+	UserName     string
+	Reason       string
+	Created      time.Time
+}
+
+type ReportModel struct {
+	DB *sql.DB
+}
+
+// Insert returns ErrConflict if the user already reported the snippet.
+func (m *ReportModel) Insert(snippetID, userID int, reason string) error {
+	statement := `INSERT INTO snippetbox.reports (snippet_id, user_id, reason, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(statement, snippetID, userID, reason)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return ErrConflict
+		}
+		if isForeignKeyViolation(err) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (m *ReportModel) Get(id int) (*Report, error) {
+	statement := `SELECT id, snippet_id, user_id, reason, created FROM snippetbox.reports WHERE id = ? AND resolved_at IS NULL`
+	r := &Report{}
+	err := m.DB.QueryRow(statement, id).Scan(&r.ID, &r.SnippetID, &r.UserID, &r.Reason, &r.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Open returns the reports that were not resolved yet, oldest first.
+func (m *ReportModel) Open() ([]*Report, error) {
+	statement := `SELECT r.id, r.snippet_id, s.title, r.user_id, u.name, r.reason, r.created
+	FROM snippetbox.reports r
+	INNER JOIN snippetbox.snippets s ON s.id = r.snippet_id
+	INNER JOIN snippetbox.users u ON u.id = r.user_id
+	WHERE r.resolved_at IS NULL
+	ORDER BY r.id`
+	rows, err := m.DB.Query(statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []*Report
+	for rows.Next() {
+		r := &Report{}
+		err = rows.Scan(&r.ID, &r.SnippetID, &r.SnippetTitle, &r.UserID, &r.UserName, &r.Reason, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// Resolve closes every open report of the snippet the report belongs to, they were all handled by the same decision.
+func (m *ReportModel) Resolve(id int) error {
+	statement := `UPDATE snippetbox.reports r
+	INNER JOIN snippetbox.reports reported ON reported.snippet_id = r.snippet_id
+	SET r.resolved_at = UTC_TIMESTAMP()
+	WHERE reported.id = ? AND r.resolved_at IS NULL`
+	result, err := m.DB.Exec(statement, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (m *ReportModel) CountOpen() (int, error) {
+	var n int
+	statement := `SELECT COUNT(*) FROM snippetbox.reports WHERE resolved_at IS NULL`
+	err := m.DB.QueryRow(statement).Scan(&n)
+
+	return n, err
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+)
+
+type Session struct {
+	ID        string
+	UserID    int
+	Created   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+}
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+func (s *SessionModel) Insert(userID int, ip, userAgent string) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	userAgent = truncate(userAgent, 255)
+
+	statement := `INSERT INTO snippetbox.user_sessions (id, user_id, created, last_seen, ip, user_agent)
+		VALUES (?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?, ?)`
+	_, err = s.DB.Exec(statement, id, userID, ip, userAgent)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// truncate shortens s to at most n characters, VARCHAR columns count characters rather than bytes.
+func truncate(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+
+	return s
+}
+
+func (s *SessionModel) Exists(id string, userID int) (bool, error) {
+	var exists bool
+	statement := `SELECT EXISTS(SELECT true FROM snippetbox.user_sessions WHERE id = ? AND user_id = ?)`
+	err := s.DB.QueryRow(statement, id, userID).Scan(&exists)
+
+	return exists, err
+}
+
+// Touch records activity, the row is written at most once a minute to keep requests cheap.
+func (s *SessionModel) Touch(id, ip string) error {
+	statement := `UPDATE snippetbox.user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ?
+		WHERE id = ? AND last_seen < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 MINUTE)`
+	_, err := s.DB.Exec(statement, ip, id)
+
+	return err
+}
+
+func (s *SessionModel) AllForUser(userID int) ([]*Session, error) {
+	statement := `SELECT id, user_id, created, last_seen, ip, user_agent FROM snippetbox.user_sessions
+		WHERE user_id = ? ORDER BY last_seen DESC`
+	rows, err := s.DB.Query(statement, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		session := &Session{}
+		err = rows.Scan(&session.ID, &session.UserID, &session.Created, &session.LastSeen, &session.IP, &session.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (s *SessionModel) Delete(id string, userID int) error {
+	statement := `DELETE FROM snippetbox.user_sessions WHERE id = ? AND user_id = ?`
+	result, err := s.DB.Exec(statement, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// DeleteAllForUser logs the user out everywhere except the session with the id exceptID, which may be empty.
+func (s *SessionModel) DeleteAllForUser(userID int, exceptID string) error {
+	statement := `DELETE FROM snippetbox.user_sessions WHERE user_id = ? AND id <> ?`
+	_, err := s.DB.Exec(statement, userID, exceptID)
+
+	return err
+}
+
+// DeleteStale removes sessions that have not been used for longer than the session lifetime.
+func (s *SessionModel) DeleteStale(olderThan time.Duration) (int, error) {
+	statement := `DELETE FROM snippetbox.user_sessions WHERE last_seen < DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)`
+	result, err := s.DB.Exec(statement, int(olderThan.Seconds()))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return int(n), err
+}
+
+// Count returns the number of sessions of logged-in users and how many of them were used within the last activeWithin.
+func (s *SessionModel) Count(activeWithin time.Duration) (total, active int, err error) {
+	statement := `SELECT COUNT(*), COALESCE(SUM(last_seen >= DATE_SUB(UTC_TIMESTAMP(), INTERVAL ? SECOND)), 0)
+		FROM snippetbox.user_sessions`
+	err = s.DB.QueryRow(statement, int(activeWithin.Seconds())).Scan(&total, &active)
+
+	return total, active, err
+}
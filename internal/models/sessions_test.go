@@ -0,0 +1,37 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{name: "short", s: "Mozilla/5.0", n: 255, want: "Mozilla/5.0"},
+		{name: "exact", s: "abc", n: 3, want: "abc"},
+		{name: "ASCII", s: "abcdef", n: 3, want: "abc"},
+		{name: "multi-byte", s: "äöüß", n: 2, want: "äö"},
+		{name: "empty", s: "", n: 3, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncate(tt.s, tt.n); got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	// a multi-byte character across the 255 byte mark is kept whole
+	userAgent := strings.Repeat("a", 254) + strings.Repeat("ü", 10)
+	got := truncate(userAgent, 255)
+	if !utf8.ValidString(got) || utf8.RuneCountInString(got) != 255 {
+		t.Errorf("got %d valid characters %v; want 255 valid", utf8.RuneCountInString(got), utf8.ValidString(got))
+	}
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// SnippetCache keeps the most recently read snippets in memory. Entries live for at most TTL, which bounds
+// how long changes made by other processes, e.g. another instance or the admin command, can go unnoticed.
+// A nil *SnippetCache is valid and caches nothing.
+type SnippetCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // front is the most recently used
+	entries map[int]*list.Element
+}
+
+type snippetCacheEntry struct {
+	snippet *Snippet
+	stored  time.Time
+}
+
+// NewSnippetCache returns a cache holding up to size snippets for ttl each.
+func NewSnippetCache(size int, ttl time.Duration) *SnippetCache {
+	return &SnippetCache{size: size, ttl: ttl, order: list.New(), entries: map[int]*list.Element{}}
+}
+
+func (c *SnippetCache) get(id int) (*Snippet, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*snippetCacheEntry)
+	now := time.Now()
+	if now.Sub(entry.stored) > c.ttl || !entry.snippet.Expires.After(now) {
+		c.order.Remove(element)
+		delete(c.entries, id)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	// callers get their own copy so they can't change the cached snippet
+	snippet := *entry.snippet
+	return &snippet, true
+}
+
+func (c *SnippetCache) put(snippet *Snippet) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	copied := *snippet
+	entry := &snippetCacheEntry{snippet: &copied, stored: time.Now()}
+	if element, ok := c.entries[snippet.ID]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[snippet.ID] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*snippetCacheEntry).snippet.ID)
+	}
+}
+
+func (c *SnippetCache) remove(id int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[id]; ok {
+		c.order.Remove(element)
+		delete(c.entries, id)
+	}
+}
+
+func (c *SnippetCache) removeUser(userID int) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, element := range c.entries {
+		if element.Value.(*snippetCacheEntry).snippet.UserID == userID {
+			c.order.Remove(element)
+			delete(c.entries, id)
+		}
+	}
+}
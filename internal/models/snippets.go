@@ -8,14 +8,19 @@ import (
 
 type Snippet struct {
 	ID      int
+	UserID  int // 0 when the snippet has no owner
 	Title   string
 	Content string
 	Created time.Time
 	Expires time.Time
+	// Updated is when the snippet last changed, Created if it never did. Only Get sets it.
+	Updated time.Time
 }
 
 type SnippetModel struct {
 	DB *sql.DB // connection pool
+	// Cache is optional, Get serves snippets from it and the methods changing snippets remove them from it
+	Cache *SnippetCache
 }
 
 func (s *SnippetModel) ExampleTransaction() error {
@@ -40,19 +45,21 @@ func (s *SnippetModel) ExampleTransaction() error {
 	return tx.Commit()
 }
 
-func (s *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (s *SnippetModel) Insert(userID int, title string, content string, expires int) (int, error) {
 	statement := `INSERT INTO snippetbox.snippets (
+					 user_id,
 					 title,
 					 content,
 					 created,
 					 expires
 					 ) VALUES (
+					   ?,
 					   ?,
 					   ?,
 					   UTC_TIMESTAMP(),
 					   DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY )
 				   )`
-	result, err := s.DB.Exec(statement, title, content, expires)
+	result, err := s.DB.Exec(statement, userID, title, content, expires)
 	if err != nil {
 		return 0, err
 	}
@@ -66,17 +73,23 @@ func (s *SnippetModel) Insert(title string, content string, expires int) (int, e
 }
 
 func (s *SnippetModel) Get(id int) (*Snippet, error) {
+	if snippet, ok := s.Cache.get(id); ok {
+		return snippet, nil
+	}
+
 	statement := `SELECT 
         id,
+        COALESCE(user_id, 0),
         title,
         content,
         created,
-        expires
+        expires,
+        COALESCE(updated, created)
         FROM snippetbox.snippets WHERE expires > UTC_TIMESTAMP() AND id = ?`
 
 	row := s.DB.QueryRow(statement, id)
 	snippet := &Snippet{}
-	err := row.Scan(&snippet.ID, &snippet.Title, &snippet.Content, &snippet.Created, &snippet.Expires)
+	err := row.Scan(&snippet.ID, &snippet.UserID, &snippet.Title, &snippet.Content, &snippet.Created, &snippet.Expires, &snippet.Updated)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -85,6 +98,7 @@ func (s *SnippetModel) Get(id int) (*Snippet, error) {
 		}
 	}
 
+	s.Cache.put(snippet)
 	return snippet, nil
 }
 
@@ -92,6 +106,7 @@ func (s *SnippetModel) Latest() ([]*Snippet, error) {
 	statement := `
 	SELECT
     	id,
+    	COALESCE(user_id, 0),
     	title,
     	content,
     	created,
@@ -110,7 +125,135 @@ func (s *SnippetModel) Latest() ([]*Snippet, error) {
 	var snippets []*Snippet
 	for rows.Next() {
 		s := &Snippet{}
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// ForgetUser removes the snippets of the user from the cache, it must be called after UserModel.Delete
+// since that changes or removes the snippets without going through SnippetModel.
+func (s *SnippetModel) ForgetUser(userID int) {
+	s.Cache.removeUser(userID)
+}
+
+// Delete removes the snippet only if it is owned by the given user.
+func (s *SnippetModel) Delete(id, userID int) error {
+	statement := `DELETE FROM snippetbox.snippets WHERE id = ? AND user_id = ?`
+	result, err := s.DB.Exec(statement, id, userID)
+	if err != nil {
+		return err
+	}
+	s.Cache.remove(id)
+
+	return checkRowsAffected(result)
+}
+
+// DeleteByID removes the snippet regardless of its owner, it is meant for administrators.
+func (s *SnippetModel) DeleteByID(id int) error {
+	statement := `DELETE FROM snippetbox.snippets WHERE id = ?`
+	result, err := s.DB.Exec(statement, id)
+	if err != nil {
+		return err
+	}
+	s.Cache.remove(id)
+
+	return checkRowsAffected(result)
+}
+
+func (s *SnippetModel) DeleteExpired() (int, error) {
+	statement := `DELETE FROM snippetbox.snippets WHERE expires <= UTC_TIMESTAMP()`
+	result, err := s.DB.Exec(statement)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return int(n), err
+}
+
+// Counts returns the number of snippets that are still visible and the number that expired but were not purged yet.
+func (s *SnippetModel) Counts() (active, expired int, err error) {
+	statement := `SELECT
+		COALESCE(SUM(expires > UTC_TIMESTAMP()), 0),
+		COALESCE(SUM(expires <= UTC_TIMESTAMP()), 0)
+	FROM snippetbox.snippets`
+	err = s.DB.QueryRow(statement).Scan(&active, &expired)
+
+	return active, expired, err
+}
+
+// Search returns at most limit snippets including expired ones whose title contains query, newest first.
+func (s *SnippetModel) Search(query string, limit int) ([]*Snippet, error) {
+	statement := `SELECT id, COALESCE(user_id, 0), title, content, created, expires FROM snippetbox.snippets
+	WHERE title LIKE ? ORDER BY id DESC LIMIT ?`
+	rows, err := s.DB.Query(statement, containsPattern(query), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []*Snippet
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// ExtendExpiry pushes the expiry date back by days, expired snippets become visible again for days from now.
+func (s *SnippetModel) ExtendExpiry(id, days int) error {
+	statement := `UPDATE snippetbox.snippets SET expires = DATE_ADD(GREATEST(expires, UTC_TIMESTAMP()), INTERVAL ? DAY),
+		updated = UTC_TIMESTAMP() WHERE id = ?`
+	result, err := s.DB.Exec(statement, days, id)
+	if err != nil {
+		return err
+	}
+	s.Cache.remove(id)
+
+	return checkRowsAffected(result)
+}
+
+// CreatedPerDay returns the number of snippets created on each of the last days that had any.
+func (s *SnippetModel) CreatedPerDay(days int) ([]DailyCount, error) {
+	statement := `SELECT DATE(created), COUNT(*) FROM snippetbox.snippets
+	WHERE created >= UTC_DATE() - INTERVAL ? DAY
+	GROUP BY DATE(created) ORDER BY DATE(created)`
+
+	return createdPerDay(s.DB, statement, days-1)
+}
+
+// All returns every snippet including expired ones, it is used to export the instance.
+func (s *SnippetModel) All() ([]*Snippet, error) {
+	statement := `SELECT id, COALESCE(user_id, 0), title, content, created, expires FROM snippetbox.snippets ORDER BY id`
+	rows, err := s.DB.Query(statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []*Snippet
+	for rows.Next() {
+		s := &Snippet{}
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires)
 		if err != nil {
 			return nil, err
 		}
@@ -123,3 +266,44 @@ func (s *SnippetModel) Latest() ([]*Snippet, error) {
 
 	return snippets, nil
 }
+
+// Restore inserts an exported snippet keeping its id and timestamps.
+// It returns false if the exact same snippet already exists and ErrConflict if a different one uses the id.
+func (s *SnippetModel) Restore(snippet *Snippet) (bool, error) {
+	var userID sql.NullInt64
+	if snippet.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(snippet.UserID), Valid: true}
+	}
+
+	statement := `INSERT INTO snippetbox.snippets (id, user_id, title, content, created, expires) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := s.DB.Exec(statement, snippet.ID, userID, snippet.Title, snippet.Content, snippet.Created.UTC(), snippet.Expires.UTC())
+	if err == nil {
+		return true, nil
+	}
+	if isForeignKeyViolation(err) {
+		// the owner does not exist on this instance
+		return false, ErrConflict
+	}
+	if !isDuplicateEntry(err) {
+		return false, err
+	}
+
+	existing := &Snippet{}
+	statement = `SELECT id, COALESCE(user_id, 0), title, content, created, expires FROM snippetbox.snippets WHERE id = ?`
+	err = s.DB.QueryRow(statement, snippet.ID).Scan(&existing.ID, &existing.UserID, &existing.Title, &existing.Content, &existing.Created, &existing.Expires)
+	if err != nil {
+		return false, err
+	}
+
+	if existing.UserID != snippet.UserID || existing.Title != snippet.Title || existing.Content != snippet.Content ||
+		!sameSecond(existing.Created, snippet.Created) || !sameSecond(existing.Expires, snippet.Expires) {
+		return false, ErrConflict
+	}
+
+	return false, nil
+}
+
+// sameSecond compares timestamps at the precision of a MySQL DATETIME column.
+func sameSecond(a, b time.Time) bool {
+	return a.Truncate(time.Second).Equal(b.Truncate(time.Second))
+}
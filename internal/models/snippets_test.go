@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnippetModelInsert(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+
+	m := &SnippetModel{DB: db}
+	id, err := m.Insert(userID, "An old silent pond", "An old silent pond...\nA frog jumps into the pond,\nsplash! Silence again.", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snippet, err := m.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if snippet.ID != id || snippet.UserID != userID {
+		t.Errorf("got snippet %d owned by %d; want %d owned by %d", snippet.ID, snippet.UserID, id, userID)
+	}
+	if snippet.Title != "An old silent pond" {
+		t.Errorf("got title %q; want %q", snippet.Title, "An old silent pond")
+	}
+	if got := snippet.Expires.Sub(snippet.Created); got != 7*24*time.Hour {
+		t.Errorf("got expiry %v after creation; want %v", got, 7*24*time.Hour)
+	}
+	if !snippet.Updated.Equal(snippet.Created) {
+		t.Errorf("got updated %v; want the creation time %v", snippet.Updated, snippet.Created)
+	}
+}
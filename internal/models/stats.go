@@ -0,0 +1,45 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// DailyCount is the number of records created on Day (UTC).
+type DailyCount struct {
+	Day   time.Time
+	Count int
+}
+
+// createdPerDay counts the rows of a query returning one DATE per row,
+// days without any rows are left out.
+func createdPerDay(db *sql.DB, statement string, args ...any) ([]DailyCount, error) {
+	rows, err := db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []DailyCount
+	for rows.Next() {
+		var c DailyCount
+		err = rows.Scan(&c.Day, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// containsPattern returns a LIKE pattern matching values that contain query literally.
+func containsPattern(query string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(query) + "%"
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"database/sql"
+	_ "github.com/go-sql-driver/mysql"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestDB opens the database named by SNIPPETBOX_TEST_DSN, e.g. "root:@/snippetbox?parseTime=true",
+// and skips the test when it is not set.
+// The queries use the snippetbox schema, so the DSN should point at a throwaway server with the migrations applied.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("SNIPPETBOX_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SNIPPETBOX_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// newTestUser inserts a user with a unique email address and removes it with its snippets when the test ends.
+func newTestUser(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	users := &UserModel{DB: db}
+	id, err := users.Insert("Test User", "test-"+strconv.FormatInt(time.Now().UnixNano(), 36)+"@example.com", "pa$$word123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := users.Delete(id, true); err != nil {
+			t.Error(err)
+		}
+	})
+
+	return id
+}
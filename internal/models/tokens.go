@@ -0,0 +1,137 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+const (
+	ScopeAuthentication    = "authentication"
+	ScopePasswordReset     = "password-reset"
+	ScopeEmailVerification = "email-verification"
+)
+
+type Token struct {
+	Plaintext string
+	Hash      []byte
+	UserID    int
+	Scope     string
+	Expiry    time.Time
+}
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+func generateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: userID,
+		Scope:  scope,
+		Expiry: time.Now().UTC().Add(ttl),
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the hash is stored, so a leaked tokens table can not be used to authenticate
+	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(token.Plaintext))
+	token.Hash = hash[:]
+
+	return token, nil
+}
+
+func (t *TokenModel) New(userID int, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := `INSERT INTO snippetbox.tokens (hash, user_id, scope, created, expiry) VALUES (?, ?, ?, UTC_TIMESTAMP(), ?)`
+	_, err = t.DB.Exec(statement, token.Hash, token.UserID, token.Scope, token.Expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// UserID returns the id of the user the plaintext token was issued to,
+// or ErrNoRecord if the token does not exist, has expired or belongs to another scope.
+func (t *TokenModel) UserID(scope, plaintext string) (int, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	var userID int
+	statement := `SELECT tokens.user_id FROM snippetbox.tokens
+		INNER JOIN snippetbox.users ON users.id = tokens.user_id
+		WHERE tokens.hash = ? AND tokens.scope = ? AND tokens.expiry > UTC_TIMESTAMP() AND NOT users.disabled`
+	err := t.DB.QueryRow(statement, hash[:], scope).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func (t *TokenModel) Delete(scope, plaintext string) error {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	statement := `DELETE FROM snippetbox.tokens WHERE hash = ? AND scope = ?`
+	_, err := t.DB.Exec(statement, hash[:], scope)
+
+	return err
+}
+
+// Consume deletes a single-use token that has not expired yet, or returns ErrNoRecord if there is none.
+// Only one of several concurrent requests with the same token can consume it.
+func (t *TokenModel) Consume(scope, plaintext string) error {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	statement := `DELETE FROM snippetbox.tokens WHERE hash = ? AND scope = ? AND expiry > UTC_TIMESTAMP()`
+	result, err := t.DB.Exec(statement, hash[:], scope)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+func (t *TokenModel) DeleteAllForUser(scope string, userID int) error {
+	statement := `DELETE FROM snippetbox.tokens WHERE scope = ? AND user_id = ?`
+	_, err := t.DB.Exec(statement, scope, userID)
+
+	return err
+}
+
+func (t *TokenModel) DeleteExpired() (int, error) {
+	statement := `DELETE FROM snippetbox.tokens WHERE expiry <= UTC_TIMESTAMP()`
+	result, err := t.DB.Exec(statement)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return int(n), err
+}
+
+// LastCreated returns when the newest token of the scope was issued to the user,
+// or the zero time if there is none. It is used to throttle emails.
+func (t *TokenModel) LastCreated(scope string, userID int) (time.Time, error) {
+	var created sql.NullTime
+	statement := `SELECT MAX(created) FROM snippetbox.tokens WHERE scope = ? AND user_id = ?`
+	err := t.DB.QueryRow(statement, scope, userID).Scan(&created)
+
+	return created.Time, err
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenModelConsume(t *testing.T) {
+	db := newTestDB(t)
+	userID := newTestUser(t, db)
+	m := &TokenModel{DB: db}
+
+	token, err := m.New(userID, time.Hour, ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Consume(ScopeAuthentication, token.Plaintext)
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("got error %v consuming it in another scope; want %v", err, ErrNoRecord)
+	}
+	err = m.Consume(ScopePasswordReset, token.Plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Consume(ScopePasswordReset, token.Plaintext)
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("got error %v consuming it twice; want %v", err, ErrNoRecord)
+	}
+
+	expired, err := m.New(userID, -time.Minute, ScopePasswordReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Consume(ScopePasswordReset, expired.Plaintext)
+	if !errors.Is(err, ErrNoRecord) {
+		t.Errorf("got error %v consuming an expired token; want %v", err, ErrNoRecord)
+	}
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// TOTPSecret returns the user's TOTP secret, or an empty string if two-factor authentication is not enabled.
+func (u *UserModel) TOTPSecret(id int) (string, error) {
+	var secret sql.NullString
+	statement := `SELECT totp_secret FROM snippetbox.users WHERE id = ?`
+	err := u.DB.QueryRow(statement, id).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+
+	return secret.String, nil
+}
+
+// EnableTwoFactor stores the confirmed secret and replaces any previous recovery codes.
+// step is the time step of the code that confirmed the secret, so that code can't be used to log in.
+func (u *UserModel) EnableTwoFactor(id int, secret string, step uint64, recoveryCodes []string) error {
+	tx, err := u.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE snippetbox.users SET totp_secret = ?, totp_last_step = ? WHERE id = ?`, secret, step, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM snippetbox.recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(`INSERT INTO snippetbox.recovery_codes (user_id, hash) VALUES (?, ?)`, id, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (u *UserModel) DisableTwoFactor(id int) error {
+	tx, err := u.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE snippetbox.users SET totp_secret = NULL, totp_last_step = NULL WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM snippetbox.recovery_codes WHERE user_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records step as the time step of the last accepted code and reports whether it is newer than
+// the previous one. The check and the update are a single statement, so concurrent logins can't both use a code.
+func (u *UserModel) UseTOTPStep(id int, step uint64) (bool, error) {
+	statement := `UPDATE snippetbox.users SET totp_last_step = ? WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)`
+	result, err := u.DB.Exec(statement, step, id, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode marks the code as used and reports whether it was valid and unused.
+func (u *UserModel) UseRecoveryCode(id int, code string) (bool, error) {
+	statement := `UPDATE snippetbox.recovery_codes SET used_at = UTC_TIMESTAMP() WHERE user_id = ? AND hash = ? AND used_at IS NULL LIMIT 1`
+	result, err := u.DB.Exec(statement, id, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so codes can be typed the way they are displayed.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
 	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
@@ -9,43 +11,137 @@ import (
 	"time"
 )
 
+// Roles are ordered, every role includes the permissions of the roles before it.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists every valid role from least to most privileged.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
 type User struct {
 	ID        int
 	Name      string
 	Email     string
 	Password  []byte
 	CreatedAt time.Time
+	Disabled  bool
+	// EmailVerifiedAt is the zero time until the user confirms their email address
+	EmailVerifiedAt  time.Time
+	TwoFactorEnabled bool
+	Role             string
+}
+
+func (u *User) EmailVerified() bool {
+	return !u.EmailVerifiedAt.IsZero()
+}
+
+// HasRole reports whether the user has role or a more privileged one.
+func (u *User) HasRole(role string) bool {
+	rank := roleRank(role)
+	return rank >= 0 && roleRank(u.Role) >= rank
 }
 
 type UserModel struct {
 	DB *sql.DB
 }
 
-func (u *UserModel) Insert(name, email, password string) error {
+// userColumns are the columns read by scanUser, the password hash is deliberately left out.
+const userColumns = `id, name, email, created_at, disabled, email_verified_at, totp_secret IS NOT NULL, role`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	user := &User{}
+	var emailVerifiedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt, &user.Disabled, &emailVerifiedAt, &user.TwoFactorEnabled, &user.Role)
+	if err != nil {
+		return nil, err
+	}
+	user.EmailVerifiedAt = emailVerifiedAt.Time
+
+	return user, nil
+}
+
+func (u *UserModel) Insert(name, email, password string) (int, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	statement := `INSERT INTO snippetbox.users (name, email, password, created_at) VALUES (?,?,?,UTC_TIMESTAMP())`
-	_, err = u.DB.Exec(statement, name, email, string(hashedPassword))
+	result, err := u.DB.Exec(statement, name, email, string(hashedPassword))
 	if err != nil {
 		var mySQLError *mysql.MySQLError
 		if errors.As(err, &mySQLError) {
 			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
+				return 0, ErrDuplicateEmail
 			}
 		}
-		return err
+		return 0, err
 	}
 
-	return nil
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Provision creates a user who signed in through an identity provider that already verified their email address.
+// The password is random so the account can only be used through the provider until the user resets it.
+func (u *UserModel) Provision(name, email string) (int, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return 0, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(base64.RawURLEncoding.EncodeToString(b)), 12)
+	if err != nil {
+		return 0, err
+	}
+
+	statement := `INSERT INTO snippetbox.users (name, email, password, created_at, email_verified_at) VALUES (?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())`
+	result, err := u.DB.Exec(statement, name, email, string(hashedPassword))
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return 0, ErrDuplicateEmail
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
 }
 
 func (u *UserModel) Authenticate(email, password string) (int, error) {
 	var id int
 	var hashedPassword []byte
 
-	statement := `SELECT id, password FROM snippetbox.users WHERE email = ?`
+	// disabled users are rejected the same way as unknown ones
+	statement := `SELECT id, password FROM snippetbox.users WHERE email = ? AND NOT disabled`
 	err := u.DB.QueryRow(statement, email).Scan(&id, &hashedPassword) // assign the variables
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -68,8 +164,303 @@ func (u *UserModel) Authenticate(email, password string) (int, error) {
 
 func (u *UserModel) Exists(id int) (bool, error) {
 	var exists bool
-	statement := `SELECT EXISTS(SELECT true FROM USERS WHERE id = ?)`
+	statement := `SELECT EXISTS(SELECT true FROM USERS WHERE id = ? AND NOT disabled)`
 	err := u.DB.QueryRow(statement, id).Scan(&exists)
 
 	return exists, err
 }
+
+func (u *UserModel) Get(id int) (*User, error) {
+	statement := `SELECT ` + userColumns + ` FROM snippetbox.users WHERE id = ?`
+	user, err := scanUser(u.DB.QueryRow(statement, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (u *UserModel) GetByEmail(email string) (*User, error) {
+	statement := `SELECT ` + userColumns + ` FROM snippetbox.users WHERE email = ?`
+	user, err := scanUser(u.DB.QueryRow(statement, email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (u *UserModel) All() ([]*User, error) {
+	statement := `SELECT ` + userColumns + ` FROM snippetbox.users ORDER BY id`
+	rows, err := u.DB.Query(statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Search returns at most limit users whose name or email contains query, newest first.
+func (u *UserModel) Search(query string, limit int) ([]*User, error) {
+	statement := `SELECT ` + userColumns + ` FROM snippetbox.users WHERE name LIKE ? OR email LIKE ? ORDER BY id DESC LIMIT ?`
+	pattern := containsPattern(query)
+	rows, err := u.DB.Query(statement, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// CreatedPerDay returns the number of signups for each of the last days that had any.
+func (u *UserModel) CreatedPerDay(days int) ([]DailyCount, error) {
+	statement := `SELECT DATE(created_at), COUNT(*) FROM snippetbox.users
+	WHERE created_at >= UTC_DATE() - INTERVAL ? DAY
+	GROUP BY DATE(created_at) ORDER BY DATE(created_at)`
+
+	return createdPerDay(u.DB, statement, days-1)
+}
+
+func (u *UserModel) SetDisabled(id int, disabled bool) error {
+	statement := `UPDATE snippetbox.users SET disabled = ? WHERE id = ?`
+	result, err := u.DB.Exec(statement, disabled, id)
+	if err != nil {
+		return err
+	}
+
+	return u.checkUpdated(result, id)
+}
+
+// checkUpdated returns ErrNoRecord if the update matched no user. MySQL only counts rows whose values
+// changed as affected, so an update that changes nothing has to check whether the user exists.
+func (u *UserModel) checkUpdated(result sql.Result, id int) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected > 0 {
+		return err
+	}
+
+	var exists bool
+	statement := `SELECT EXISTS(SELECT true FROM snippetbox.users WHERE id = ?)`
+	err = u.DB.QueryRow(statement, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (u *UserModel) SetRole(id int, role string) error {
+	statement := `UPDATE snippetbox.users SET role = ? WHERE id = ?`
+	result, err := u.DB.Exec(statement, role, id)
+	if err != nil {
+		return err
+	}
+
+	return u.checkUpdated(result, id)
+}
+
+func (u *UserModel) UpdatePassword(id int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	statement := `UPDATE snippetbox.users SET password = ? WHERE id = ?`
+	result, err := u.DB.Exec(statement, string(hashedPassword), id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Counts returns the total number of users and how many of them are disabled.
+func (u *UserModel) Counts() (total, disabled int, err error) {
+	statement := `SELECT COUNT(*), COALESCE(SUM(disabled), 0) FROM snippetbox.users`
+	err = u.DB.QueryRow(statement).Scan(&total, &disabled)
+
+	return total, disabled, err
+}
+
+// Export returns every user including their password hash, it is used to export the instance.
+func (u *UserModel) Export() ([]*User, error) {
+	statement := `SELECT id, name, email, password, created_at, disabled, email_verified_at, role FROM snippetbox.users ORDER BY id`
+	rows, err := u.DB.Query(statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user := &User{}
+		var emailVerifiedAt sql.NullTime
+		err = rows.Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.CreatedAt, &user.Disabled, &emailVerifiedAt, &user.Role)
+		if err != nil {
+			return nil, err
+		}
+		user.EmailVerifiedAt = emailVerifiedAt.Time
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Restore inserts an exported user keeping its id, password hash and creation time.
+// It returns false if the exact same user already exists and ErrConflict if the id or email belong to someone else.
+func (u *UserModel) Restore(user *User) (bool, error) {
+	var emailVerifiedAt sql.NullTime
+	if user.EmailVerified() {
+		emailVerifiedAt = sql.NullTime{Time: user.EmailVerifiedAt.UTC(), Valid: true}
+	}
+
+	role := user.Role
+	if role == "" {
+		role = RoleUser
+	}
+
+	statement := `INSERT INTO snippetbox.users (id, name, email, password, created_at, disabled, email_verified_at, role) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := u.DB.Exec(statement, user.ID, user.Name, user.Email, string(user.Password), user.CreatedAt.UTC(), user.Disabled, emailVerifiedAt, role)
+	if err == nil {
+		return true, nil
+	}
+	if !isDuplicateEntry(err) {
+		return false, err
+	}
+
+	existing := &User{}
+	var existingEmailVerifiedAt sql.NullTime
+	statement = `SELECT id, name, email, password, created_at, disabled, email_verified_at, role FROM snippetbox.users WHERE id = ?`
+	err = u.DB.QueryRow(statement, user.ID).Scan(&existing.ID, &existing.Name, &existing.Email, &existing.Password,
+		&existing.CreatedAt, &existing.Disabled, &existingEmailVerifiedAt, &existing.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// the id is free, so the email address is taken by another user
+			return false, ErrConflict
+		}
+		return false, err
+	}
+
+	if existing.Name != user.Name || existing.Email != user.Email || string(existing.Password) != string(user.Password) ||
+		existing.Disabled != user.Disabled || !sameSecond(existing.CreatedAt, user.CreatedAt) ||
+		existing.Role != role || !sameSecond(existingEmailVerifiedAt.Time, emailVerifiedAt.Time) {
+		return false, ErrConflict
+	}
+
+	return false, nil
+}
+
+// VerifyPassword returns ErrInvalidCredentials if password is not the user's current password,
+// it is used to confirm sensitive account changes.
+func (u *UserModel) VerifyPassword(id int, password string) error {
+	var hashedPassword []byte
+	statement := `SELECT password FROM snippetbox.users WHERE id = ?`
+	err := u.DB.QueryRow(statement, id).Scan(&hashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	return nil
+}
+
+// PasswordUpdate changes the password after confirming the current one,
+// it returns ErrInvalidCredentials if the current password does not match.
+func (u *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+	err := u.VerifyPassword(id, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	return u.UpdatePassword(id, newPassword)
+}
+
+func (u *UserModel) MarkEmailVerified(id int) error {
+	statement := `UPDATE snippetbox.users SET email_verified_at = UTC_TIMESTAMP() WHERE id = ? AND email_verified_at IS NULL`
+	_, err := u.DB.Exec(statement, id)
+
+	return err
+}
+
+// Delete removes the user in a single transaction, their snippets are deleted too when deleteSnippets is set
+// and kept without an owner otherwise. Sessions, tokens, recovery codes and reports go with the user through the foreign keys.
+func (u *UserModel) Delete(id int, deleteSnippets bool) error {
+	tx, err := u.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if deleteSnippets {
+		_, err = tx.Exec(`DELETE FROM snippetbox.snippets WHERE user_id = ?`, id)
+	} else {
+		_, err = tx.Exec(`UPDATE snippetbox.snippets SET user_id = NULL, updated = UTC_TIMESTAMP() WHERE user_id = ?`, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM snippetbox.users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	err = checkRowsAffected(result)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
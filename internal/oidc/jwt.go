@@ -0,0 +1,213 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is tolerated between us and the provider when checking exp and iat.
+const clockSkew = time.Minute
+
+var (
+	ErrInvalidToken = errors.New("oidc: invalid id token")
+	ErrExpiredToken = errors.New("oidc: expired id token")
+)
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	IssuedAt int64    `json:"iat"`
+	Nonce    string   `json:"nonce"`
+	Email    string   `json:"email"`
+	// some providers send email_verified as the string "true"
+	EmailVerified any    `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// audience accepts both forms of the aud claim, a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	err := json.Unmarshal(b, &multiple)
+	if err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature and claims of an ID token and returns the claims.
+// nonce must be the value sent with the authorization request.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (*Claims, error) {
+	_, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	err = decodeSegment(parts[0], &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	// the algorithm is fixed instead of taken from the token to rule out "none" and HMAC confusion attacks
+	if h.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Algorithm)
+	}
+
+	key, err := p.keys.get(ctx, p, h.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var c claims
+	err = decodeSegment(parts[1], &c)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	switch {
+	case strings.TrimRight(c.Issuer, "/") != p.config.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, c.Issuer)
+	case !c.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidToken)
+	case c.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case c.IssuedAt > now.Add(clockSkew).Unix():
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case c.Expiry < now.Add(-clockSkew).Unix():
+		return nil, ErrExpiredToken
+	}
+
+	return &Claims{
+		Issuer:        c.Issuer,
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: c.EmailVerified == true || c.EmailVerified == "true",
+		Name:          c.Name,
+	}, nil
+}
+
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
+
+// keySet caches the provider's signing keys, they are fetched again when a token uses an unknown key id
+// because providers rotate their keys.
+type keySet struct {
+	uri       string
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// minRefreshInterval keeps tokens with made up key ids from making us hammer the provider.
+const minRefreshInterval = time.Minute
+
+func (k *keySet) get(ctx context.Context, p *Provider, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := k.keys[kid]
+	stale := time.Since(k.fetchedAt) > minRefreshInterval
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	err := p.getJSON(ctx, k.uri, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: jwks: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.mu.Lock()
+	k.keys = keys
+	k.fetchedAt = time.Now()
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
@@ -0,0 +1,198 @@
+// Package oidc implements the parts of OpenID Connect needed to log users in with an external identity provider:
+// discovery, the authorization code flow with PKCE and verification of RS256 signed ID tokens.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes a client registered with an identity provider.
+type Config struct {
+	// Issuer is the issuer URL, the discovery document is fetched from Issuer + "/.well-known/openid-configuration"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL registered with the provider
+	RedirectURL string
+	// Scopes are requested in addition to "openid"
+	Scopes []string
+}
+
+// Claims are the claims of a verified ID token that are used to find or create a user.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is safe for concurrent use. The discovery document is fetched on first use
+// so that the server starts even when a provider is unreachable.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     *keySet
+}
+
+// New returns a provider for config, client is used for every request to the provider and may be nil.
+func New(config Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	config.Issuer = strings.TrimRight(config.Issuer, "/")
+
+	return &Provider{config: config, client: client}
+}
+
+// AuthCodeURL returns the URL of the provider's login page. state and nonce must be random values that are
+// checked again in the callback, verifier is the PKCE code verifier sent along with the code in Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClientID)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	v.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return m.AuthorizationEndpoint + separator + v.Encode(), nil
+}
+
+// Exchange trades the authorization code for an ID token and returns its verified claims.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var output struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&output)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token response: %w", err)
+	}
+	if output.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint: %s %s", output.Error, output.ErrorDescription)
+	}
+	if res.StatusCode != http.StatusOK || output.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token endpoint responded with %s and no id_token", res.Status)
+	}
+
+	return p.Verify(ctx, output.IDToken, nonce)
+}
+
+// discover fetches and caches the provider metadata.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	m := &metadata{}
+	err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", m)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if strings.TrimRight(m.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", m.Issuer, p.config.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: incomplete provider metadata")
+	}
+
+	p.metadata = m
+	p.keys = &keySet{uri: m.JWKSURI}
+	return m, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s responded with %s", url, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dst)
+}
+
+// RandomString returns a random URL-safe string suitable for the state, nonce and PKCE verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,249 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"github.com/danyelkeddah/snippetbox/internal/oidc"
+	"github.com/danyelkeddah/snippetbox/internal/oidc/oidctest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redirectURL = "http://localhost:4000/user/login/oidc/test/callback"
+
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+
+	srv := oidctest.NewServer()
+	t.Cleanup(srv.Close)
+
+	provider := oidc.New(oidc.Config{
+		Issuer:       srv.URL,
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email", "profile"},
+	}, nil)
+
+	return srv, provider
+}
+
+// authorize runs the provider's side of the login and returns the code and state it redirects back with.
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, verifier string) (string, string) {
+	t.Helper()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != redirectURL {
+		t.Fatalf("got redirect to %q; want %q", got, redirectURL)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestLogin(t *testing.T) {
+	srv, provider := newProvider(t)
+	srv.SetUser(oidctest.User{Subject: "alice", Email: "alice@example.com", EmailVerified: true, Name: "Alice"})
+
+	code, state := authorize(t, provider, "state", "nonce", "verifier")
+	if state != "state" {
+		t.Errorf("got state %q; want %q", state, "state")
+	}
+
+	claims, err := provider.Exchange(context.Background(), code, "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := oidc.Claims{Issuer: srv.URL, Subject: "alice", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+	if *claims != want {
+		t.Errorf("got %+v; want %+v", *claims, want)
+	}
+}
+
+func TestLoginUnverifiedEmail(t *testing.T) {
+	srv, provider := newProvider(t)
+	srv.SetUser(oidctest.User{Subject: "bob", Email: "bob@example.com"})
+
+	code, _ := authorize(t, provider, "state", "nonce", "verifier")
+	claims, err := provider.Exchange(context.Background(), code, "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.EmailVerified {
+		t.Error("got a verified email address; want unverified")
+	}
+}
+
+func TestExchangeRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		nonce    string
+		verifier string
+		// reuse exchanges the code a second time
+		reuse bool
+	}{
+		{name: "wrong nonce", nonce: "other", verifier: "verifier"},
+		{name: "wrong PKCE verifier", nonce: "nonce", verifier: "other"},
+		{name: "code used twice", nonce: "nonce", verifier: "verifier", reuse: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, provider := newProvider(t)
+			code, _ := authorize(t, provider, "state", "nonce", "verifier")
+
+			if tt.reuse {
+				_, err := provider.Exchange(context.Background(), code, "nonce", "verifier")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := provider.Exchange(context.Background(), code, tt.nonce, tt.verifier)
+			if err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		kid     string
+		change  func(claims map[string]any)
+		wantErr error
+	}{
+		{
+			name:   "valid",
+			kid:    oidctest.KeyID,
+			change: func(claims map[string]any) {},
+		},
+		{
+			name:   "audience as an array",
+			kid:    oidctest.KeyID,
+			change: func(claims map[string]any) { claims["aud"] = []string{"other", oidctest.ClientID} },
+		},
+		{
+			name:    "wrong nonce",
+			kid:     oidctest.KeyID,
+			change:  func(claims map[string]any) { claims["nonce"] = "other" },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name:    "wrong audience",
+			kid:     oidctest.KeyID,
+			change:  func(claims map[string]any) { claims["aud"] = "other-client" },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name:    "wrong issuer",
+			kid:     oidctest.KeyID,
+			change:  func(claims map[string]any) { claims["iss"] = "https://attacker.example.com" },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name:    "missing subject",
+			kid:     oidctest.KeyID,
+			change:  func(claims map[string]any) { delete(claims, "sub") },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name: "expired",
+			kid:  oidctest.KeyID,
+			change: func(claims map[string]any) {
+				claims["iat"] = time.Now().Add(-time.Hour).Unix()
+				claims["exp"] = time.Now().Add(-10 * time.Minute).Unix()
+			},
+			wantErr: oidc.ErrExpiredToken,
+		},
+		{
+			name:    "issued in the future",
+			kid:     oidctest.KeyID,
+			change:  func(claims map[string]any) { claims["iat"] = time.Now().Add(time.Hour).Unix() },
+			wantErr: oidc.ErrInvalidToken,
+		},
+		{
+			name:    "unknown key id",
+			kid:     "unknown",
+			change:  func(claims map[string]any) {},
+			wantErr: oidc.ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, provider := newProvider(t)
+
+			claims := srv.Claims("nonce")
+			tt.change(claims)
+			token, err := srv.Sign(tt.kid, claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = provider.Verify(context.Background(), token, "nonce")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyTamperedToken(t *testing.T) {
+	srv, provider := newProvider(t)
+
+	token, err := srv.Sign(oidctest.KeyID, srv.Claims("nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := srv.Sign(oidctest.KeyID, map[string]any{"sub": "mallory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the claims of one token with the signature of another
+	parts := strings.Split(token, ".")
+	otherParts := strings.Split(other, ".")
+	for _, tampered := range []string{parts[0] + "." + parts[1] + "." + otherParts[2], parts[0] + "." + parts[1], "not a token"} {
+		_, err = provider.Verify(context.Background(), tampered, "nonce")
+		if !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("got error %v; want %v", err, oidc.ErrInvalidToken)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	// the provider's discovery document names a different issuer than the URL it is reached at
+	srv := httptest.NewServer(oidctest.NewProvider("https://sso.example.com"))
+	defer srv.Close()
+
+	provider := oidc.New(oidc.Config{Issuer: srv.URL, ClientID: oidctest.ClientID, RedirectURL: redirectURL}, nil)
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("got error %v; want an issuer mismatch", err)
+	}
+}
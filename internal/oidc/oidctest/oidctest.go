@@ -0,0 +1,269 @@
+// Package oidctest provides a fake OpenID Connect provider for tests and local development.
+//
+// The provider logs in every authorization request as the configured user without showing a login page,
+// so the whole flow runs against localhost without any network access:
+//
+//	srv := oidctest.NewServer()
+//	defer srv.Close()
+//	srv.SetUser(oidctest.User{Subject: "alice", Email: "alice@example.com", EmailVerified: true})
+//	provider := oidc.New(oidc.Config{Issuer: srv.URL, ClientID: oidctest.ClientID, ClientSecret: oidctest.ClientSecret, ...}, nil)
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ClientID and ClientSecret are the only client credentials the provider accepts.
+const (
+	ClientID     = "snippetbox"
+	ClientSecret = "secret"
+)
+
+// KeyID is the key id of the signing key published on the JWKS endpoint.
+const KeyID = "oidctest"
+
+// User is the identity put into the ID tokens.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type authorization struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	user        User
+}
+
+// Provider is an http.Handler serving the discovery document, signing keys and the authorization and token endpoints.
+type Provider struct {
+	// Issuer must be the URL the provider is reachable at
+	Issuer string
+
+	mu    sync.Mutex
+	user  User
+	key   *rsa.PrivateKey
+	codes map[string]authorization
+	mux   *http.ServeMux
+}
+
+// NewProvider returns a provider for issuer that logs everyone in as a verified test user until SetUser is called.
+func NewProvider(issuer string) *Provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	p := &Provider{
+		Issuer: issuer,
+		user:   User{Subject: "test-user", Email: "test@example.com", EmailVerified: true, Name: "Test User"},
+		key:    key,
+		codes:  map[string]authorization{},
+		mux:    http.NewServeMux(),
+	}
+	p.mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	p.mux.HandleFunc("/jwks", p.jwks)
+	p.mux.HandleFunc("/authorize", p.authorize)
+	p.mux.HandleFunc("/token", p.token)
+
+	return p
+}
+
+// SetUser changes the identity returned by the following logins.
+func (p *Provider) SetUser(user User) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.user = user
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// Server is a Provider listening on a random local port.
+type Server struct {
+	*Provider
+	URL    string
+	server *httptest.Server
+}
+
+func NewServer() *Server {
+	s := &Server{}
+	s.server = httptest.NewUnstartedServer(nil)
+	// the issuer has to be known before the first request, the listener already has its address
+	s.URL = "http://" + s.server.Listener.Addr().String()
+	s.Provider = NewProvider(s.URL)
+	s.server.Config.Handler = s.Provider
+	s.server.Start()
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": KeyID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize skips the login page and redirects straight back with a code for the current user.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != ClientID || q.Get("response_type") != "code" || redirectURI.Scheme == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		user:        p.user,
+	}
+	p.mu.Unlock()
+
+	v := redirectURI.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirectURI.RawQuery = v.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != ClientID || clientSecret != ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || auth.redirectURI != r.PostFormValue("redirect_uri") ||
+		(auth.challenge != "" && auth.challenge != base64.RawURLEncoding.EncodeToString(challenge[:])) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := p.Sign(KeyID, p.claims(auth.user, auth.clientID, auth.nonce))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// Claims returns the claims of a valid ID token for the current user, tests can change them and sign them
+// with Sign to get tokens that should be rejected.
+func (p *Provider) Claims(nonce string) map[string]any {
+	p.mu.Lock()
+	user := p.user
+	p.mu.Unlock()
+
+	return p.claims(user, ClientID, nonce)
+}
+
+func (p *Provider) claims(user User, clientID, nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            p.Issuer,
+		"sub":            user.Subject,
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"name":           user.Name,
+	}
+}
+
+// Sign returns an ID token with claims signed by the provider's key. kid is put into the header,
+// KeyID is the id the provider publishes its key under.
+func (p *Provider) Sign(kid string, claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,135 @@
+// Package ratelimit implements per-key token buckets, e.g. one bucket per client IP address.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate allows Limit requests per Per, a bucket holds at most Limit tokens so a full bucket allows a burst of Limit.
+type Rate struct {
+	Limit int
+	Per   time.Duration
+}
+
+// ParseRate parses rates like "20/s", "10/m" or "100/h".
+func ParseRate(s string) (Rate, error) {
+	limit, unit, found := strings.Cut(s, "/")
+	if !found {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected e.g. 10/m", s)
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 {
+		return Rate{}, fmt.Errorf("invalid rate %q, limit must be a positive number", s)
+	}
+
+	var per time.Duration
+	switch unit {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return Rate{}, fmt.Errorf("invalid rate %q, unit must be s, m or h", s)
+	}
+
+	return Rate{Limit: n, Per: per}, nil
+}
+
+func (r Rate) String() string {
+	switch r.Per {
+	case time.Second:
+		return fmt.Sprintf("%d/s", r.Limit)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", r.Limit)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", r.Limit)
+	}
+	return fmt.Sprintf("%d/%s", r.Limit, r.Per)
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type Limiter struct {
+	rate    Rate
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func New(rate Rate) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the key's bucket. If the bucket is empty it returns false
+// and how long the client has to wait for the next token.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	perSecond := float64(l.rate.Limit) / l.rate.Per.Seconds()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Limit), lastSeen: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.lastSeen).Seconds() * perSecond
+	if b.tokens > float64(l.rate.Limit) {
+		b.tokens = float64(l.rate.Limit)
+	}
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+		return false, wait
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// Cleanup removes buckets that have been refilled completely, they behave exactly like missing ones.
+func (l *Limiter) Cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if time.Since(b.lastSeen) > l.rate.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup every interval until the returned function is called.
+func (l *Limiter) StartCleanup(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Cleanup()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
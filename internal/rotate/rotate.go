@@ -0,0 +1,114 @@
+// Package rotate implements a log file that is rotated once it grows beyond a maximum size.
+//
+// When the file is rotated it is renamed to <path>.1, an existing <path>.1 becomes <path>.2 and so on,
+// files beyond MaxBackups are removed.
+package rotate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is an io.WriteCloser that is safe for concurrent use, the file is opened on the first write.
+type File struct {
+	Path string
+	// MaxSize is the size in bytes after which the file is rotated, 0 disables rotation
+	MaxSize int64
+	// MaxBackups is the number of rotated files that are kept
+	MaxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		err := f.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// a single write is never split across files, even if it is larger than MaxSize
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *File) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+
+	if f.MaxBackups < 1 {
+		err = os.Remove(f.Path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return f.open()
+	}
+
+	err = os.Remove(backupName(f.Path, f.MaxBackups))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := f.MaxBackups - 1; i >= 1; i-- {
+		err = os.Rename(backupName(f.Path, i), backupName(f.Path, i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	err = os.Rename(f.Path, backupName(f.Path, 1))
+	if err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+func backupName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// secret is the SHA-1 key from the test vectors of RFC 6238, "12345678901234567890" encoded as base32
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		// the RFC lists 8 digit codes, these are their last 6 digits
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d: got %q; want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		wantStep uint64
+		wantOK   bool
+	}{
+		{"current period", now, Step(now), true},
+		{"one period later", now.Add(period), Step(now), true},
+		{"one period earlier", now.Add(-period), Step(now), true},
+		{"two periods later", now.Add(2 * period), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Match(secret, code, tt.at)
+			if step != tt.wantStep || ok != tt.wantOK {
+				t.Errorf("got %d, %v; want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+
+	if _, ok := Match(secret, "12345", now); ok {
+		t.Error("accepted a code with too few digits")
+	}
+}
@@ -0,0 +1,17 @@
+-- API tokens are stored as SHA-256 hashes, the plaintext is only ever shown to the client once.
+CREATE TABLE snippetbox.tokens
+(
+    hash    BINARY(32) PRIMARY KEY,
+    user_id INTEGER      NOT NULL,
+    scope   VARCHAR(32)  NOT NULL,
+    created DATETIME     NOT NULL,
+    expiry  DATETIME     NOT NULL,
+    CONSTRAINT tokens_fk_user_id FOREIGN KEY (user_id) REFERENCES snippetbox.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_tokens_user_id_scope ON snippetbox.tokens (user_id, scope);
+
+-- Snippets created before this migration have no owner.
+ALTER TABLE snippetbox.snippets
+    ADD COLUMN user_id INTEGER NULL,
+    ADD CONSTRAINT snippets_fk_user_id FOREIGN KEY (user_id) REFERENCES snippetbox.users (id) ON DELETE SET NULL;
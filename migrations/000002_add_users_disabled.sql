@@ -0,0 +1,3 @@
+-- Disabled users can no longer log in, their sessions and API tokens stop working.
+ALTER TABLE snippetbox.users
+    ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,6 @@
+-- NULL until the user opens the link sent to them on signup.
+ALTER TABLE snippetbox.users
+    ADD COLUMN email_verified_at DATETIME NULL;
+
+-- Accounts that existed before verification was introduced are trusted.
+UPDATE snippetbox.users SET email_verified_at = created_at;
@@ -0,0 +1,15 @@
+-- Two-factor authentication is enabled when totp_secret is set.
+ALTER TABLE snippetbox.users
+    ADD COLUMN totp_secret VARCHAR(64) NULL;
+
+-- Recovery codes are stored as SHA-256 hashes and can each be used once.
+CREATE TABLE snippetbox.recovery_codes
+(
+    id      INTEGER    NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER    NOT NULL,
+    hash    BINARY(32) NOT NULL,
+    used_at DATETIME   NULL,
+    CONSTRAINT recovery_codes_fk_user_id FOREIGN KEY (user_id) REFERENCES snippetbox.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON snippetbox.recovery_codes (user_id);
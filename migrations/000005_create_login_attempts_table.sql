@@ -0,0 +1,7 @@
+-- Failed login attempts per account and per IP address, used when running with -lockout-store=db.
+CREATE TABLE snippetbox.login_attempts
+(
+    attempt_key  VARCHAR(255) NOT NULL PRIMARY KEY,
+    failures     INTEGER      NOT NULL,
+    last_failure DATETIME     NOT NULL
+);
@@ -0,0 +1,14 @@
+-- Metadata about each logged in session, the scs session stores the id of its row under "sessionID".
+-- Deleting a row logs that session out on its next request.
+CREATE TABLE snippetbox.user_sessions
+(
+    id         CHAR(32)     NOT NULL PRIMARY KEY,
+    user_id    INTEGER      NOT NULL,
+    created    DATETIME     NOT NULL,
+    last_seen  DATETIME     NOT NULL,
+    ip         VARCHAR(45)  NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    CONSTRAINT user_sessions_fk_user_id FOREIGN KEY (user_id) REFERENCES snippetbox.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user_id ON snippetbox.user_sessions (user_id);
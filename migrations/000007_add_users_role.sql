@@ -0,0 +1,3 @@
+-- Roles grant access to the admin pages: moderators can delete any snippet, admins can also manage users.
+ALTER TABLE snippetbox.users
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user';
@@ -0,0 +1,16 @@
+-- Snippets reported by users for moderators to review, a user can report each snippet once.
+-- Reports disappear together with the snippet, resolved ones are kept for reference.
+CREATE TABLE snippetbox.reports
+(
+    id          INTEGER      NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id  INTEGER      NOT NULL,
+    user_id     INTEGER      NOT NULL,
+    reason      VARCHAR(500) NOT NULL,
+    created     DATETIME     NOT NULL,
+    resolved_at DATETIME     NULL,
+    CONSTRAINT reports_uc_snippet_user UNIQUE (snippet_id, user_id),
+    CONSTRAINT reports_fk_snippet_id FOREIGN KEY (snippet_id) REFERENCES snippetbox.snippets (id) ON DELETE CASCADE,
+    CONSTRAINT reports_fk_user_id FOREIGN KEY (user_id) REFERENCES snippetbox.users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_reports_resolved_at ON snippetbox.reports (resolved_at);
@@ -0,0 +1,12 @@
+-- Accounts at external OpenID Connect providers linked to users, a user can log in with any of them.
+-- Users created through a provider get a random password that they can replace with a password reset.
+CREATE TABLE snippetbox.user_identities
+(
+    id      INTEGER      NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER      NOT NULL,
+    issuer  VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    created DATETIME     NOT NULL,
+    CONSTRAINT user_identities_uc_issuer_subject UNIQUE (issuer, subject),
+    CONSTRAINT user_identities_fk_user_id FOREIGN KEY (user_id) REFERENCES snippetbox.users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- Set when a snippet changes after it was created, e.g. when its expiry is extended or its owner deleted their account,
+-- so snippet pages can send a Last-Modified header. NULL means the snippet did not change since it was created.
+ALTER TABLE snippetbox.snippets
+    ADD COLUMN updated DATETIME NULL;
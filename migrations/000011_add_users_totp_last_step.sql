@@ -0,0 +1,4 @@
+-- The time step of the last TOTP code that was accepted, a code is valid for several steps and must only
+-- be usable once. Codes with a step at or below this one are rejected.
+ALTER TABLE snippetbox.users
+    ADD COLUMN totp_last_step BIGINT UNSIGNED NULL;